@@ -0,0 +1,237 @@
+package vio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+)
+
+// a change to the metadata of a version made after it was committed.
+// Annotations are never rewritten, so they keep the history of the
+// metadata of each version.
+type annotation struct {
+	Version string   `json:"version"`
+	Author  string   `json:"author"`
+	Date    int64    `json:"date"`
+	Set     metadata `json:"set,omitempty"`
+	Unset   []string `json:"unset,omitempty"`
+}
+
+// describes an annotation in a single line
+func (a annotation) String() string {
+	changes := []string{}
+	keys := []string{}
+	for k := range a.Set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		changes = append(changes, fmt.Sprintf("set %s=%s", k, formatValue(a.Set[k])))
+	}
+	for _, k := range a.Unset {
+		changes = append(changes, "unset "+k)
+	}
+	return fmt.Sprintf("%s by %s: %s",
+		time.Unix(a.Date, 0).Format("Mon Jan 2 15:04:05 2006 -0700"),
+		a.Author, strings.Join(changes, "; "))
+}
+
+// parses annotations stored one JSON object per line
+func parseAnnotations(contents []byte) (annotations []annotation, err error) {
+	annotations = []annotation{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var a annotation
+		d := json.NewDecoder(strings.NewReader(line))
+		d.UseNumber()
+		if err = d.Decode(&a); err != nil {
+			return nil, AnError{"Malformed annotation: " + line}
+		}
+		annotations = append(annotations, a)
+	}
+	return
+}
+
+func formatAnnotation(a annotation) ([]byte, error) {
+	line, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// reads the annotations stored in '<snapshots>/annotations' by the backends
+// that keep the index in the local filesystem
+func readAnnotationsFile(snapsPath string) ([]annotation, error) {
+	contents, err := ioutil.ReadFile(snapsPath + "/annotations")
+	if os.IsNotExist(err) {
+		return []annotation{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseAnnotations(contents)
+}
+
+// appends an annotation to '<snapshots>/annotations', holding the lock of
+// the index while doing so
+func appendAnnotationFile(snapsPath string, a annotation) (err error) {
+	line, err := formatAnnotation(a)
+	if err != nil {
+		return
+	}
+
+	flock, err := lockIndex(snapsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	f, err := os.OpenFile(snapsPath+"/annotations", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(line)
+	return
+}
+
+// returns copies of the given versions with their annotations applied, in
+// the order in which they were made
+func applyAnnotations(versions []version, annotations []annotation) []version {
+	byVersion := map[string][]annotation{}
+	for _, a := range annotations {
+		byVersion[a.Version] = append(byVersion[a.Version], a)
+	}
+
+	annotated := []version{}
+	for _, v := range versions {
+		as := byVersion[v.id()]
+		if len(as) > 0 {
+			meta := v.meta.copy()
+			for _, a := range as {
+				for k, value := range a.Set {
+					meta[k] = value
+				}
+				for _, k := range a.Unset {
+					meta.remove(k)
+				}
+			}
+			v.meta = meta
+		}
+		annotated = append(annotated, v)
+	}
+	return annotated
+}
+
+// returns the versions of a backend with the current values of their
+// metadata, i.e. after applying their annotations
+func annotatedVersions(b Backend) (versions []version, err error) {
+	versions, err = b.GetVersions()
+	if err != nil {
+		return
+	}
+	annotations, err := b.GetAnnotations()
+	if err != nil {
+		return
+	}
+	return applyAnnotations(versions, annotations), nil
+}
+
+// returns the annotations of a version
+func annotationsOf(b Backend, v *version) (annotations []annotation, err error) {
+	all, err := b.GetAnnotations()
+	if err != nil {
+		return
+	}
+	for _, a := range all {
+		if a.Version == v.id() {
+			annotations = append(annotations, a)
+		}
+	}
+	return
+}
+
+// identifies who annotates a version, using the git identity if there's one
+func annotationAuthor() string {
+	name, errName := runCmd(".", "git config user.name")
+	email, errEmail := runCmd(".", "git config user.email")
+	if errName == nil && errEmail == nil {
+		return strings.TrimSpace(name) + " <" + strings.TrimSpace(email) + ">"
+	}
+
+	username := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	host, _ := os.Hostname()
+	return username + "@" + host
+}
+
+// changes the metadata of a committed version, setting and removing the
+// given keys. Values are parsed as JSON if they are valid JSON, so that
+// numbers and booleans keep their type, and kept as strings otherwise. Keys
+// to remove can be paths of nested objects, as in 'params.threads'. The
+// change is recorded along with who made it and when.
+func Annotate(ref string, set map[string]string, unset []string) (err error) {
+	if len(set) == 0 && len(unset) == 0 {
+		return AnError{"Nothing to annotate"}
+	}
+
+	b, err := load()
+	if err != nil {
+		return
+	}
+	v, err := resolveVersion(b, ref)
+	if err != nil {
+		return
+	}
+	for _, k := range unset {
+		if _, ok := v.meta.get(k); !ok {
+			return AnError{"Key '" + k + "' not in metadata of version " + v.id()}
+		}
+		if _, ok := set[k]; ok {
+			return AnError{"Key '" + k + "' can't be both set and unset"}
+		}
+	}
+
+	values := metadata{}
+	for k, value := range set {
+		values[k] = parseValue(value)
+	}
+	return b.Annotate(annotation{
+		Version: v.id(),
+		Author:  annotationAuthor(),
+		Date:    time.Now().Unix(),
+		Set:     values,
+		Unset:   unset})
+}
+
+// shows the history of annotations of a version
+func AnnotationHistory(ref string) (history string, err error) {
+	b, err := load()
+	if err != nil {
+		return
+	}
+	v, err := resolveVersion(b, ref)
+	if err != nil {
+		return
+	}
+	annotations, err := annotationsOf(b, v)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	for _, a := range annotations {
+		buf.WriteString(a.String() + "\n")
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,104 @@
+package vio
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyAnnotations(t *testing.T) {
+	versions := []version{
+		*NewVersionWithMeta("ca82a6d#1448281434", metadata{"message": "first", "threads": "32"}),
+		*NewVersionWithMeta("ca82a6d#1448304512", metadata{"message": "second",
+			"params": map[string]interface{}{"lr": json.Number("0.1"), "seed": json.Number("7")}}),
+	}
+	annotations := []annotation{
+		{Version: "ca82a6d#1448281434", Set: metadata{"valid": false}},
+		{Version: "ca82a6d#1448281434", Set: metadata{"valid": true}, Unset: []string{"threads"}},
+		{Version: "ca82a6d#1448304512", Unset: []string{"params.seed"}},
+	}
+
+	annotated := applyAnnotations(versions, annotations)
+	assert.Equal(t, annotated[0].meta, metadata{"message": "first", "valid": true})
+	assert.Equal(t, annotated[1].meta, metadata{"message": "second",
+		"params": map[string]interface{}{"lr": json.Number("0.1")}})
+
+	// the original versions are left untouched
+	assert.Equal(t, versions[0].meta["threads"], "32")
+	_, ok := versions[0].meta["valid"]
+	assert.False(t, ok)
+	assert.Equal(t, versions[1].meta.str("params.seed"), "7")
+}
+
+func testAnnotate(t *testing.T, backend string) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	err = Init(".snapshots", backend)
+	assert.Nil(t, err)
+
+	id, err := GetCurrentCommitId(path)
+	assert.Nil(t, err)
+	v := NewVersionWithMeta(id+"#1448281434", metadata{"message": "first", "threads": "64"})
+	assert.Nil(t, addVersionToIndex(v, ".snapshots/index"))
+
+	assert.NotNil(t, Annotate("@", map[string]string{}, nil))
+	assert.NotNil(t, Annotate("@", nil, []string{"missing"}))
+
+	assert.Nil(t, Annotate("@", map[string]string{"valid": "false"}, nil))
+	assert.Nil(t, Annotate("@",
+		map[string]string{"message": "first, in the paper", "paper": "fig3"}, []string{"threads"}))
+
+	logstr, err := Log()
+	assert.Nil(t, err)
+	assert.Equal(t, logstr, id+"#1448281434 first, in the paper\n")
+
+	logstr, err = LogWithOptions(LogOptions{Where: []string{"paper=fig3", "valid=false"}})
+	assert.Nil(t, err)
+	assert.Equal(t, logstr, id+"#1448281434 first, in the paper\n")
+	logstr, err = LogWithOptions(LogOptions{Where: []string{"threads=64"}})
+	assert.Nil(t, err)
+	assert.Equal(t, logstr, "")
+
+	// values are typed as JSON, and nested keys can be removed
+	assert.Nil(t, Annotate("@", map[string]string{
+		"threads": "128", "keep": "true", "params": `{"lr": 0.1, "seed": 7}`, "note": `"64"`}, nil))
+	assert.Nil(t, Annotate("@", nil, []string{"params.seed"}))
+	b, err := load()
+	assert.Nil(t, err)
+	vs, err := annotatedVersions(b)
+	assert.Nil(t, err)
+	assert.Equal(t, vs[0].meta["threads"], json.Number("128"))
+	assert.Equal(t, vs[0].meta["keep"], true)
+	assert.Equal(t, vs[0].meta["note"], "64")
+	assert.Equal(t, vs[0].meta["params"], map[string]interface{}{"lr": json.Number("0.1")})
+	logstr, err = LogWithOptions(LogOptions{Where: []string{"threads>64"}})
+	assert.Nil(t, err)
+	assert.Equal(t, logstr, id+"#1448281434 first, in the paper\n")
+	assert.NotNil(t, Annotate("@", nil, []string{"params.seed"}))
+
+	// the index itself isn't rewritten
+	contents, err := ioutil.ReadFile(".snapshots/index")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), indexOf(t, v))
+
+	history, err := AnnotationHistory("@")
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(history), "\n")
+	assert.Equal(t, len(lines), 4)
+	assert.Contains(t, lines[0], ": set valid=false")
+	assert.Contains(t, lines[1], ": set message=first, in the paper; set paper=fig3; unset threads")
+	assert.Contains(t, lines[1], " by ")
+}
+
+func TestAnnotatePosix(t *testing.T) {
+	testAnnotate(t, "posix")
+}
+
+func TestAnnotateGit(t *testing.T) {
+	testAnnotate(t, "git")
+}
@@ -0,0 +1,359 @@
+package vio
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// a bundle is a tar archive with the snapshots folder of a CasBackend
+// repository, which contains the index, tags and annotations of the
+// bundled versions, their manifests (with the SHA-256 checksum of each
+// file) and the files themselves. It also contains bundleFile, which
+// describes the bundle. Bundles are compressed according to the extension
+// of their name: zstd for '.zst' (using the zstd tool), gzip for '.gz' and
+// '.tgz', none otherwise.
+const bundleFile = "bundle.json"
+
+// bundles of version 1 contain a format 1 index; since version 2 they
+// contain a format 2 one, which older releases of vio can't read
+const bundleFormat = "vio-bundle"
+const bundleFormatVersion = 2
+
+type bundleInfo struct {
+	Format   string   `json:"format"`
+	Version  int      `json:"version"`
+	Vio      string   `json:"vio"`
+	Created  int64    `json:"created"`
+	Versions []string `json:"versions"`
+}
+
+// returns a CasBackend whose snapshots folder is dir
+func openBundleDir(dir string) *CasBackend {
+	return &CasBackend{snapshotsPath: dir, repoPath: "."}
+}
+
+// returns a writer that compresses what is written to it according to the
+// extension of path, and writes it there
+func compressedWriter(path string) (io.WriteCloser, error) {
+	if strings.HasSuffix(path, ".zst") {
+		cmd := exec.Command("zstd", "-q", "-f", "-o", path)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err = cmd.Start(); err != nil {
+			return nil, AnError{"Can't run zstd: " + err.Error()}
+		}
+		return &cmdWriter{stdin, cmd}, nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz") {
+		return &gzipWriter{gzip.NewWriter(f), f}, nil
+	}
+	return f, nil
+}
+
+type cmdWriter struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (w *cmdWriter) Close() error {
+	w.WriteCloser.Close()
+	return w.cmd.Wait()
+}
+
+type gzipWriter struct {
+	*gzip.Writer
+	f *os.File
+}
+
+func (w *gzipWriter) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// returns a reader of the uncompressed contents of a bundle, detecting the
+// compression from the first bytes of the file
+func decompressedReader(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	magic, _ := r.Peek(4)
+
+	switch {
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		f.Close()
+		cmd := exec.Command("zstd", "-d", "-q", "-c", path)
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err = cmd.Start(); err != nil {
+			return nil, AnError{"Can't run zstd: " + err.Error()}
+		}
+		return &cmdReader{stdout, cmd}, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &readCloser{gz, f.Close}, nil
+	}
+	return &readCloser{r, f.Close}, nil
+}
+
+// writes the contents of a folder as a tar archive, with the given file
+// first
+func writeTar(w io.Writer, dir string, first string) (err error) {
+	files, err := listDir(dir)
+	if err != nil {
+		return
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i] == first && files[j] != first })
+
+	tw := tar.NewWriter(w)
+	for _, file := range files {
+		info, err := os.Stat(dir + "/" + file)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = file
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		f, err := os.Open(dir + "/" + file)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// extracts a tar archive into a folder. Only regular files and folders
+// inside of it are accepted.
+func extractTar(r io.Reader, dir string) (err error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return AnError{"Malformed bundle: " + err.Error()}
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return AnError{"Unexpected path in bundle: " + hdr.Name}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dir+"/"+name, 0755)
+		case tar.TypeReg:
+			err = restoreFile(tr, dir+"/"+name, 0644)
+		default:
+			err = AnError{"Unexpected entry in bundle: " + hdr.Name}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checks that an extracted bundle is complete and that its files match
+// their checksums
+func verifyBundle(b *CasBackend) (err error) {
+	contents, err := ioutil.ReadFile(b.snapshotsPath + "/" + bundleFile)
+	if err != nil {
+		return AnError{"Not a vio bundle, " + bundleFile + " is missing"}
+	}
+	var info bundleInfo
+	if err = json.Unmarshal(contents, &info); err != nil || info.Format != bundleFormat {
+		return AnError{"Not a vio bundle, malformed " + bundleFile}
+	}
+	if info.Version > bundleFormatVersion {
+		return AnError{fmt.Sprintf("Unsupported bundle version %d", info.Version)}
+	}
+
+	versions, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+	if len(versions) != len(info.Versions) {
+		return AnError{fmt.Sprintf("Bundle should contain %d versions, but has %d",
+			len(info.Versions), len(versions))}
+	}
+	for _, id := range info.Versions {
+		v, err := parseVersion(id, nil)
+		if err != nil {
+			return err
+		}
+		if err = checkInIndex(b, v); err != nil {
+			return err
+		}
+	}
+
+	verified := map[string]bool{}
+	for i := range versions {
+		manifest, err := b.readManifest(&versions[i])
+		if err != nil {
+			return AnError{"Missing manifest of version " + versions[i].id()}
+		}
+		for _, e := range manifest {
+			if verified[e.Digest] {
+				continue
+			}
+			r, err := openObject(b.objectPath(e.Digest))
+			if err != nil {
+				return err
+			}
+			digest, size, err := digestOf(r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+			if digest != e.Digest || size != e.Size {
+				return AnError{"Checksum mismatch for " + e.Path + " of version " + versions[i].id()}
+			}
+			verified[e.Digest] = true
+		}
+	}
+	return
+}
+
+// writes the given versions, or all of them if none is given, to a bundle.
+// Versions are further selected by the revision, time range and metadata
+// predicates in o, whose format is ignored.
+func Export(output string, refs []string, o LogOptions) (report string, err error) {
+	b, err := load()
+	if err != nil {
+		return
+	}
+	versions, err := annotatedVersions(b)
+	if err != nil {
+		return
+	}
+	if len(refs) > 0 {
+		versions = []version{}
+		for _, ref := range refs {
+			v, err := resolveVersion(b, ref)
+			if err != nil {
+				return "", err
+			}
+			versions = append(versions, *v)
+		}
+	}
+	filter, err := newVersionFilter(o.Revision, o.Since, o.Until, o.Where)
+	if err != nil {
+		return
+	}
+	ids := []string{}
+	for _, v := range filter.apply(versions) {
+		ids = append(ids, v.id())
+	}
+	if len(ids) == 0 {
+		return "", AnError{"No versions to export"}
+	}
+
+	tmp, err := ioutil.TempDir("", "vio-bundle-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmp)
+	bundle := openBundleDir(tmp + "/bundle")
+	if err = bundle.Init(); err != nil {
+		return
+	}
+	if report, err = syncVersions(b, bundle, ids, "exported"); err != nil {
+		return
+	}
+
+	info, err := json.Marshal(bundleInfo{bundleFormat, bundleFormatVersion, Version, time.Now().Unix(), ids})
+	if err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(tmp+"/bundle/"+bundleFile, info, 0644); err != nil {
+		return
+	}
+
+	w, err := compressedWriter(output)
+	if err != nil {
+		return
+	}
+	if err = writeTar(w, tmp+"/bundle", bundleFile); err != nil {
+		w.Close()
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	return report, nil
+}
+
+// verifies a bundle and copies its versions, tags and annotations into the
+// repository. As with 'vio pull', nothing is copied if a version is in both
+// with different metadata.
+func Import(path string) (report string, err error) {
+	b, err := load()
+	if err != nil {
+		return
+	}
+
+	tmp, err := ioutil.TempDir("", "vio-bundle-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmp)
+
+	r, err := decompressedReader(path)
+	if err != nil {
+		return
+	}
+	err = extractTar(r, tmp)
+	if closeErr := r.Close(); err == nil && closeErr != nil {
+		err = AnError{"Can't decompress bundle: " + closeErr.Error()}
+	}
+	if err != nil {
+		return
+	}
+
+	bundle := openBundleDir(tmp)
+	if !bundle.IsInitialized() {
+		return "", AnError{"Not a vio bundle: " + path}
+	}
+	if err = verifyBundle(bundle); err != nil {
+		return
+	}
+	return syncVersions(bundle, b, nil, "imported")
+}
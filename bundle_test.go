@@ -0,0 +1,195 @@
+package vio
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// creates a repo with three versions and returns its path and the IDs of
+// the versions, oldest first
+func createBundleTestRepo(t *testing.T) (path string, ids []string) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	assert.Nil(t, Init(".snapshots", "posix"))
+
+	for i, msg := range []string{"first", "second", "third"} {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		assert.Nil(t, ioutil.WriteFile("results.txt", []byte(msg), 0644))
+		assert.Nil(t, Commit(msg, "{}"))
+	}
+	assert.Nil(t, Tag("baseline", "@~1", false))
+	assert.Nil(t, Annotate("@~1", map[string]string{"valid": "false"}, nil))
+
+	b, err := load()
+	assert.Nil(t, err)
+	vs, err := annotatedVersions(b)
+	assert.Nil(t, err)
+	for _, v := range vs {
+		ids = append(ids, v.id())
+	}
+	return
+}
+
+// returns a clone of the project repo at path, with a vio repo that uses the
+// given backend
+func cloneBundleTestRepo(t *testing.T, path string, backend string) {
+	other, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, err)
+	_, err = runCmd(other, "git clone --quiet "+path+" .")
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(other))
+	assert.Nil(t, Init(".snapshots", backend))
+}
+
+func testBundle(t *testing.T, bundle string) {
+	path, ids := createBundleTestRepo(t)
+
+	_, err := Export(path+"/"+bundle, []string{"no-such-version"}, LogOptions{})
+	assert.NotNil(t, err)
+	_, err = Export(path+"/"+bundle, nil, LogOptions{Since: "1d", Until: "1h"})
+	assert.NotNil(t, err)
+
+	b, err := load()
+	assert.Nil(t, err)
+	since, err := resolveVersion(b, "baseline")
+	assert.Nil(t, err)
+	report, err := Export(path+"/"+bundle, nil,
+		LogOptions{Revision: "HEAD", Since: strconv.FormatInt(since.timestamp.Unix(), 10)})
+	assert.Nil(t, err)
+	assert.Equal(t, report, "exported "+ids[1]+" second\nexported "+ids[2]+" third\n"+
+		"2 versions exported, 0 already present\n")
+	logstr, err := LogWithOptions(LogOptions{Format: "full", Since: strconv.FormatInt(since.timestamp.Unix(), 10)})
+	assert.Nil(t, err)
+
+	cloneBundleTestRepo(t, path, "cas")
+	report, err = Import(path + "/" + bundle)
+	assert.Nil(t, err)
+	assert.Contains(t, report, "2 versions imported, 0 already present\n")
+	report, err = Import(path + "/" + bundle)
+	assert.Nil(t, err)
+	assert.Equal(t, report, "0 versions imported, 2 already present\n")
+
+	imported, err := LogWithOptions(LogOptions{Format: "full"})
+	assert.Nil(t, err)
+	assert.Equal(t, imported, logstr)
+	showstr, err := Show("baseline", false)
+	assert.Nil(t, err)
+	assert.Contains(t, showstr, "set valid=false")
+}
+
+func TestBundleTar(t *testing.T) {
+	testBundle(t, "runs.tar")
+}
+
+func TestBundleGzip(t *testing.T) {
+	testBundle(t, "runs.tar.gz")
+}
+
+func TestBundleZstd(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd is not installed")
+	}
+	testBundle(t, "runs.tar.zst")
+}
+
+func TestBundleVerification(t *testing.T) {
+	path, _ := createBundleTestRepo(t)
+	_, err := Export(path+"/runs.tar", []string{"@"}, LogOptions{})
+	assert.Nil(t, err)
+
+	// alter the contents of the bundled results
+	contents, err := ioutil.ReadFile(path + "/runs.tar")
+	assert.Nil(t, err)
+	var tampered bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(contents))
+	tw := tar.NewWriter(&tampered)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		data, err := ioutil.ReadAll(tr)
+		assert.Nil(t, err)
+		if string(data) == "third" {
+			data = []byte("fifth")
+		}
+		assert.Nil(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(data)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, ioutil.WriteFile(path+"/tampered.tar", tampered.Bytes(), 0644))
+
+	cloneBundleTestRepo(t, path, "posix")
+	_, err = Import(path + "/tampered.tar")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Checksum mismatch for results.txt")
+	logstr, err := Log()
+	assert.Nil(t, err)
+	assert.Equal(t, logstr, "")
+
+	_, err = Import(path + "/README")
+	assert.NotNil(t, err)
+
+	_, err = Import(path + "/runs.tar")
+	assert.Nil(t, err)
+	logstr, err = Log()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(logstr, " third\n"))
+}
+
+func TestBundleVersion(t *testing.T) {
+	path, _ := createBundleTestRepo(t)
+	_, err := Export(path+"/runs.tar", []string{"@"}, LogOptions{})
+	assert.Nil(t, err)
+
+	// claim a bundle version newer than the supported one
+	contents, err := ioutil.ReadFile(path + "/runs.tar")
+	assert.Nil(t, err)
+	var newer bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(contents))
+	tw := tar.NewWriter(&newer)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		data, err := ioutil.ReadAll(tr)
+		assert.Nil(t, err)
+		if strings.HasSuffix(hdr.Name, bundleFile) {
+			var info bundleInfo
+			assert.Nil(t, json.Unmarshal(data, &info))
+			assert.Equal(t, info.Version, 2)
+			info.Version = 3
+			data, err = json.Marshal(info)
+			assert.Nil(t, err)
+			hdr.Size = int64(len(data))
+		}
+		assert.Nil(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(data)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, ioutil.WriteFile(path+"/newer.tar", newer.Bytes(), 0644))
+
+	cloneBundleTestRepo(t, path, "posix")
+	_, err = Import(path + "/newer.tar")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Unsupported bundle version 3")
+}
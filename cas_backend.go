@@ -0,0 +1,641 @@
+package vio
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/ini.v1"
+)
+
+// CasBackend stores files in a content-addressable store, so that files
+// with the same contents are stored only once regardless of how many
+// snapshots contain them. Each unique file is stored in
+// '<snapshots>/objects/<sha256[0:2]>/<sha256>' and each snapshot is a
+// manifest, stored in '<snapshots>/manifests/<revision>/<timestamp>', that
+// maps paths to the digest of their contents.
+type CasBackend struct {
+	snapshotsPath string
+	repoPath      string
+}
+
+// an entry in the manifest of a snapshot
+type manifestEntry struct {
+	Path   string      `json:"path"`
+	Size   int64       `json:"size"`
+	Mode   os.FileMode `json:"mode"`
+	Digest string      `json:"sha256"`
+}
+
+func NewCasBackend(o *ini.File) (b Backend, err error) {
+	if !o.Section("").HasKey("snapshots_path") {
+		return nil, AnError{"Expecting key 'snapshots_path' in configuration."}
+	}
+	if !o.Section("").HasKey("repo_path") {
+		return nil, AnError{"Expecting key 'repo_path' in configuration."}
+	}
+	return &CasBackend{
+		snapshotsPath: o.Section("").Key("snapshots_path").String(),
+		repoPath:      o.Section("").Key("repo_path").String()}, nil
+}
+
+func (b CasBackend) objectsPath() string {
+	return b.snapshotsPath + "/objects"
+}
+
+func (b CasBackend) objectPath(digest string) string {
+	return fmt.Sprintf("%s/%s/%s", b.objectsPath(), digest[0:2], digest)
+}
+
+func (b CasBackend) manifestPath(v *version) string {
+	return fmt.Sprintf("%s/manifests/%s/%d", b.snapshotsPath, v.revision, v.timestamp.Unix())
+}
+
+func (b CasBackend) Init() (err error) {
+	if err = os.Mkdir(b.snapshotsPath, 0755); err != nil {
+		return
+	}
+
+	if _, err = os.Stat(b.snapshotsPath + "/index"); err == nil {
+		return AnError{"Repository already initialized"}
+	}
+
+	if err = os.Mkdir(b.objectsPath(), 0755); err != nil {
+		return
+	}
+	if err = os.Mkdir(b.snapshotsPath+"/manifests", 0755); err != nil {
+		return
+	}
+
+	if err = ioutil.WriteFile(b.snapshotsPath+"/index", emptyIndex(), 0644); err != nil {
+		return
+	}
+
+	return
+}
+
+func (b CasBackend) Open() error {
+	return nil
+}
+
+func (b CasBackend) IsInitialized() bool {
+	if _, err := os.Stat(b.objectsPath()); err != nil {
+		return false
+	}
+	_, err := os.Stat(b.snapshotsPath + "/index")
+	return err == nil
+}
+
+func (b CasBackend) GetStatus() (Status, error) {
+	return stagingStatus(b.repoPath)
+}
+
+func (b CasBackend) Checkout(v *version) (err error) {
+	if err = checkRepo(b, b.repoPath); err != nil {
+		return
+	}
+
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	if err = checkInIndex(b, v); err != nil {
+		return
+	}
+
+	manifest, err := b.readManifest(v)
+	if err != nil {
+		return
+	}
+	for _, e := range manifest {
+		if err = restoreObject(b.objectPath(e.Digest), b.repoPath+"/"+e.Path, e.Mode); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (b CasBackend) Commit(meta metadata) (v *version, err error) {
+	if err = checkCommit(b, b.repoPath, meta); err != nil {
+		return
+	}
+	versionedFiles, err := GetVersionedFiles(b.repoPath)
+	if err != nil {
+		return
+	}
+
+	id, err := GetCurrentCommitId(b.repoPath)
+	if err != nil {
+		return
+	}
+
+	v = NewVersionWithMeta(id, meta)
+
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	idx, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+	if ContainsVersion(idx, v) {
+		return nil, AnError{"Version " + fmt.Sprintf("%v", v) + " already in index."}
+	}
+
+	files, err := filesToCommit(b.repoPath, b.snapshotsPath, versionedFiles)
+	if err != nil {
+		return
+	}
+
+	manifest, err := b.storeFiles(b.repoPath, files)
+	if err != nil {
+		return
+	}
+
+	if err = b.writeManifest(v, manifest); err != nil {
+		return
+	}
+	if err = writeSidecar(b.snapshotsPath, v); err != nil {
+		return
+	}
+
+	if err = addVersionToIndex(v, b.snapshotsPath+"/index"); err != nil {
+		return
+	}
+
+	err = clearStaged(b.repoPath)
+	return
+}
+
+// stores the given files of a folder and returns the manifest that lists
+// them. Files that are neither regular nor symlinks are skipped.
+func (b CasBackend) storeFiles(root string, files []string) (manifest []manifestEntry, err error) {
+	manifest = []manifestEntry{}
+	for _, file := range files {
+		info, err := os.Lstat(root + "/" + file)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		digest, size, err := storeObject(root+"/"+file, b.objectsPath(), b.objectPath)
+		if err != nil {
+			return nil, err
+		}
+		manifest = append(manifest, manifestEntry{file, size, info.Mode(), digest})
+	}
+	return
+}
+
+func (b CasBackend) Import(v *version, dir string) (err error) {
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	idx, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+	if ContainsVersion(idx, v) {
+		return AnError{"Version " + v.id() + " already in index."}
+	}
+
+	files, err := listDir(dir)
+	if err != nil {
+		return
+	}
+	manifest, err := b.storeFiles(dir, files)
+	if err != nil {
+		return
+	}
+	if err = b.writeManifest(v, manifest); err != nil {
+		return
+	}
+	if err = writeSidecar(b.snapshotsPath, v); err != nil {
+		return
+	}
+	return addVersionToIndex(v, b.snapshotsPath+"/index")
+}
+
+func (b CasBackend) readManifest(v *version) (manifest []manifestEntry, err error) {
+	return readManifestFile(b.manifestPath(v))
+}
+
+func (b CasBackend) writeManifest(v *version, manifest []manifestEntry) (err error) {
+	return writeManifestFile(b.manifestPath(v), manifest)
+}
+
+func readManifestFile(path string) (manifest []manifestEntry, err error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(contents, &manifest); err != nil {
+		return nil, AnError{"Malformed manifest " + path}
+	}
+	return
+}
+
+func writeManifestFile(path string, manifest []manifestEntry) (err error) {
+	contents, err := json.Marshal(manifest)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	return ioutil.WriteFile(path, contents, 0644)
+}
+
+// returns the manifest of the regular files and symlinks of a folder
+func manifestOf(dir string) (manifest []manifestEntry, err error) {
+	files, err := listDir(dir)
+	if err != nil {
+		return
+	}
+	manifest = []manifestEntry{}
+	for _, file := range files {
+		info, err := os.Lstat(dir + "/" + file)
+		if err != nil {
+			return nil, err
+		}
+		src, closer, err := openSource(dir + "/" + file)
+		if err != nil {
+			return nil, err
+		}
+		digest, size, err := digestOf(src)
+		closer()
+		if err != nil {
+			return nil, err
+		}
+		manifest = append(manifest, manifestEntry{file, size, info.Mode(), digest})
+	}
+	return
+}
+
+func (b CasBackend) GetVersions() (versions []version, err error) {
+	return readIndex(b.snapshotsPath + "/index")
+}
+
+func (b CasBackend) GetTags() (map[string]string, error) {
+	return readTagsFile(b.snapshotsPath)
+}
+
+func (b CasBackend) SetTag(name string, v *version) error {
+	return updateTagsFile(b.snapshotsPath, func(tags map[string]string) error {
+		return addTag(tags, name, v)
+	})
+}
+
+func (b CasBackend) DeleteTag(name string) error {
+	return updateTagsFile(b.snapshotsPath, func(tags map[string]string) error {
+		return removeTag(tags, name)
+	})
+}
+
+func (b CasBackend) GetAnnotations() ([]annotation, error) {
+	return readAnnotationsFile(b.snapshotsPath)
+}
+
+func (b CasBackend) Annotate(a annotation) error {
+	return appendAnnotationFile(b.snapshotsPath, a)
+}
+
+func (b CasBackend) Remove(vs []*version) error {
+	return removeFromIndexFile(b.snapshotsPath+"/index", vs)
+}
+
+// removes the manifests of versions removed from the index, and then the
+// objects that no remaining manifest refers to
+func (b CasBackend) GC(dryRun bool) (removed []string, freed int64, err error) {
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	idx, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+	kept := map[string]bool{}
+	referenced := map[string]bool{}
+	for i := range idx {
+		manifest, err := b.readManifest(&idx[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		kept[filepath.Clean(b.manifestPath(&idx[i]))] = true
+		for _, e := range manifest {
+			referenced[e.Digest] = true
+		}
+	}
+	removedVersions, err := readRemovedFile(b.snapshotsPath)
+	if err != nil {
+		return
+	}
+
+	manifests := b.snapshotsPath + "/manifests"
+	err = filepath.Walk(manifests, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || kept[filepath.Clean(path)] {
+			return nil
+		}
+		if !collectable(path, removedVersions) {
+			v, _ := versionOfPath(path)
+			manifest, err := b.readManifest(v)
+			if err != nil {
+				return err
+			}
+			for _, e := range manifest {
+				referenced[e.Digest] = true
+			}
+			return nil
+		}
+		removed = append(removed, path)
+		freed += info.Size()
+		if dryRun {
+			return nil
+		}
+		if v, ok := versionOfPath(path); ok {
+			if err := removeSidecar(b.snapshotsPath, v); err != nil {
+				return err
+			}
+		}
+		return os.Remove(path)
+	})
+	if err != nil {
+		return
+	}
+	if !dryRun {
+		if err = removeEmptyDirs(manifests); err != nil {
+			return
+		}
+		if _, err = os.Stat(b.snapshotsPath + "/metadata"); err == nil {
+			err = removeEmptyDirs(b.snapshotsPath + "/metadata")
+		} else if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	objects, objectsFreed, err := gcObjects(b.objectsPath(), referenced, dryRun)
+	if err != nil {
+		return
+	}
+	return append(removed, objects...), freed + objectsFreed, nil
+}
+
+func (b CasBackend) Diff(v1 *version, v2 *version, obj string) (string, error) {
+	if err := checkInIndex(b, v1, v2); err != nil {
+		return "", err
+	}
+	return diffVersions(b, v1, v2, obj)
+}
+
+func (b CasBackend) ListFiles(v *version) (files []FileInfo, err error) {
+	manifest, err := b.readManifest(v)
+	if err != nil {
+		return
+	}
+	files = []FileInfo{}
+	for _, e := range manifest {
+		files = append(files, FileInfo{Path: e.Path, Size: e.Size, Mode: e.Mode})
+	}
+	return
+}
+
+func (b CasBackend) OpenFile(v *version, path string) (io.ReadCloser, error) {
+	manifest, err := b.readManifest(v)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range manifest {
+		if e.Path == path {
+			return openObject(b.objectPath(e.Digest))
+		}
+	}
+	return nil, AnError{"File " + path + " not in version " + v.revision}
+}
+
+// opens a file for reading, or the target of a symlink if path is one
+func openSource(path string) (src io.ReadSeeker, closer func() error, err error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bytes.NewReader([]byte(target)), func() error { return nil }, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	return f, f.Close, nil
+}
+
+// returns the SHA-256 digest and size of the contents of a reader
+func digestOf(r io.Reader) (digest string, size int64, err error) {
+	h := sha256.New()
+	if size, err = io.Copy(h, r); err != nil {
+		return
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// copies a file (or the target of a symlink) into a content-addressable
+// store, unless an object with the same contents is already there. The
+// path of an object is given by objectPath, and tmpDir has to be in the
+// same filesystem as the objects.
+func storeObject(path string, tmpDir string,
+	objectPath func(digest string) string) (digest string, size int64, err error) {
+
+	src, closer, err := openSource(path)
+	if err != nil {
+		return
+	}
+	defer closer()
+
+	// hash first, so that files that are already stored are never copied
+	if digest, size, err = digestOf(src); err != nil {
+		return
+	}
+
+	dest := objectPath(digest)
+	if _, err = os.Stat(dest); err == nil {
+		return
+	}
+
+	if _, err = src.Seek(0, 0); err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return
+	}
+
+	// write to a temporary file first so that a partial copy never ends up
+	// being taken for the object
+	tmp, err := ioutil.TempFile(tmpDir, "tmp-")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(tmp.Name(), 0444); err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), dest)
+	return
+}
+
+func openObject(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, AnError{"Missing object " + filepath.Base(path)}
+	}
+	return f, err
+}
+
+// copies an object to the given path, or creates a symlink to the target
+// stored in the object
+func restoreObject(objectPath string, path string, mode os.FileMode) (err error) {
+	src, err := openObject(objectPath)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	return restoreFile(src, path, mode)
+}
+
+// writes the contents of a reader to the given path, or creates a symlink
+// to the target read from it if mode is a symlink
+func restoreFile(src io.Reader, path string, mode os.FileMode) (err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	if mode&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(string(target), path)
+	}
+
+	dest, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(dest, src); err != nil {
+		dest.Close()
+		return
+	}
+	if err = dest.Close(); err != nil {
+		return
+	}
+	// the umask might have been applied when creating the file
+	return os.Chmod(path, mode.Perm())
+}
+
+// checks the index, and that the objects of every version match the
+// digests in its manifest
+func (b CasBackend) Fsck(repair bool) (problems []problem, err error) {
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	versions, problems, err := fsckIndexFile(b.snapshotsPath, func(v *version) (bool, error) {
+		return exists(b.manifestPath(v))
+	}, repair)
+	if err != nil {
+		return
+	}
+
+	kept := map[string]bool{}
+	checked := map[string]string{}
+	open := func(digest string) (io.ReadCloser, error) {
+		return openObject(b.objectPath(digest))
+	}
+	for i := range versions {
+		v := &versions[i]
+		kept[filepath.Clean(b.manifestPath(v))] = true
+		manifest, err := b.readManifest(v)
+		if err != nil {
+			problems = append(problems, problem{msg: "version " + v.id() + ": " + err.Error()})
+			continue
+		}
+		problems = append(problems, verifyObjects(v, manifest, open, checked)...)
+	}
+
+	removed, err := readRemovedFile(b.snapshotsPath)
+	if err != nil {
+		return
+	}
+	err = filepath.Walk(b.snapshotsPath+"/manifests", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || kept[filepath.Clean(path)] {
+			return err
+		}
+		problems = append(problems, unindexedProblem("manifest", path, removed))
+		return nil
+	})
+	return
+}
+
+// rebuilds the index from the stored manifests and their sidecar files
+func (b CasBackend) Reindex(dryRun bool) ([]version, error) {
+	return reindexFile(b.snapshotsPath, func() (found []version, err error) {
+		err = filepath.Walk(b.snapshotsPath+"/manifests", func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			v, ok := versionOfPath(path)
+			if !ok {
+				return nil
+			}
+			if v.meta, err = readSidecar(b.snapshotsPath, v); err != nil {
+				return err
+			}
+			found = append(found, *v)
+			return nil
+		})
+		return
+	}, dryRun)
+}
+
+// migrates the index to the current format
+func (b CasBackend) UpgradeIndex() (int, error) {
+	return upgradeIndexFile(b.snapshotsPath)
+}
@@ -0,0 +1,228 @@
+package vio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/ini.v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getNewCasBackend(t *testing.T, path string) (b Backend) {
+	opts := ini.Empty()
+	assert.NotNil(t, opts)
+
+	opts.Section("").Key("repo_path").SetValue(path)
+	opts.Section("").Key("snapshots_path").SetValue(path + "/.snapshots")
+	opts.Section("").Key("backend_type").SetValue("cas")
+
+	b, err := InstantiateBackend(opts)
+	assert.NotNil(t, b)
+	assert.Nil(t, err)
+
+	return
+}
+
+func countObjects(t *testing.T, path string) (n int) {
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			n++
+		}
+		return err
+	})
+	assert.Nil(t, err)
+	return
+}
+
+func TestCasBackendInit(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewCasBackend(t, path)
+
+	assert.False(t, backend.IsInitialized())
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	assert.True(t, backend.IsInitialized())
+
+	_, err = os.Stat(path + "/.snapshots/index")
+	assert.Nil(t, err)
+	_, err = os.Stat(path + "/.snapshots/objects")
+	assert.Nil(t, err)
+}
+
+func TestCasBackendCommit(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewCasBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("yeah"), 0644)
+	assert.Nil(t, err)
+	err = os.Mkdir(path+"/folder", 0755)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/folder/toz", []byte("ok"), 0755)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/folder/same", []byte("ok"), 0644)
+	assert.Nil(t, err)
+
+	v, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+
+	files, err := backend.ListFiles(v)
+	assert.Nil(t, err)
+	assert.Equal(t, files, []FileInfo{
+		{Path: "bar", Size: 4, Mode: 0644},
+		{Path: "folder/same", Size: 2, Mode: 0644},
+		{Path: "folder/toz", Size: 2, Mode: 0755}})
+
+	// files with the same contents are stored once
+	assert.Equal(t, countObjects(t, path+"/.snapshots/objects"), 2)
+
+	contents, err := readFile(backend, v, "bar")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "yeah")
+
+	_, err = backend.OpenFile(v, "README")
+	assert.NotNil(t, err)
+
+	contents, err = ioutil.ReadFile(path + "/.snapshots/index")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), indexOf(t, v))
+}
+
+func TestCasBackendCommitWithIgnore(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewCasBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("yeah"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/.vioignore", []byte("ignored_folder\n"), 0644)
+	assert.Nil(t, err)
+	err = os.Mkdir(path+"/ignored_folder", 0755)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/ignored_folder/foo", []byte("ignore this\n"), 0644)
+	assert.Nil(t, err)
+	_, err = runCmd(path, "git add .vioignore")
+	assert.Nil(t, err)
+	_, err = runCmd(path, "git commit -m committing_vio_ignored_files")
+	assert.Nil(t, err)
+
+	v, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+
+	files, err := backend.ListFiles(v)
+	assert.Nil(t, err)
+	assert.Equal(t, len(files), 1)
+	assert.Equal(t, files[0].Path, "bar")
+}
+
+func TestCasBackendCheckout(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewCasBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("yeah"), 0644)
+	assert.Nil(t, err)
+	err = os.Mkdir(path+"/folder", 0755)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/folder/toz", []byte("ok"), 0755)
+	assert.Nil(t, err)
+	err = os.Symlink("bar", path+"/link")
+	assert.Nil(t, err)
+
+	v, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+
+	err = os.Remove(path + "/bar")
+	assert.Nil(t, err)
+	err = os.RemoveAll(path + "/folder")
+	assert.Nil(t, err)
+	err = os.Remove(path + "/link")
+	assert.Nil(t, err)
+
+	err = backend.Checkout(v)
+	assert.Nil(t, err)
+
+	contents, err := ioutil.ReadFile(path + "/bar")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "yeah")
+	info, err := os.Stat(path + "/folder/toz")
+	assert.Nil(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0755))
+	target, err := os.Readlink(path + "/link")
+	assert.Nil(t, err)
+	assert.Equal(t, target, "bar")
+
+	// checked out files can be modified without altering the store
+	err = ioutil.WriteFile(path+"/bar", []byte("changed"), 0644)
+	assert.Nil(t, err)
+	contents, err = readFile(backend, v, "bar")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "yeah")
+}
+
+func TestCasBackendDedup(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewCasBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/input", []byte("a large dataset"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/output", []byte("one\ntwo\n"), 0644)
+	assert.Nil(t, err)
+
+	v1, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+	assert.Equal(t, countObjects(t, path+"/.snapshots/objects"), 2)
+
+	err = ioutil.WriteFile(path+"/output", []byte("one\n2\n"), 0644)
+	assert.Nil(t, err)
+
+	time.Sleep(time.Second)
+
+	v2, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+
+	// the input is not stored again
+	assert.Equal(t, countObjects(t, path+"/.snapshots/objects"), 3)
+
+	d, err := backend.Diff(v1, v2, "")
+	assert.Nil(t, err)
+	assert.Contains(t, d, "modified: output\n")
+	assert.NotContains(t, d, "input")
+}
@@ -0,0 +1,433 @@
+package vio
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// options of Compare
+type CompareOptions struct {
+	// the values to compare. A key is either a metadata key or a field of
+	// a JSON or CSV file of the snapshots, written 'file:field'. See
+	// readField for how fields are selected.
+	Keys []string
+
+	// version that changes are relative to; the first one by default
+	Baseline string
+
+	// one of 'text' (the default), 'csv', 'markdown' or 'json'
+	Format string
+}
+
+// a value of a key in a version. Values that aren't numbers, including NaN
+// and infinities, are shown, but left out of statistics and changes.
+type comparedValue struct {
+	raw      string
+	number   float64
+	isNumber bool
+	found    bool
+}
+
+func newComparedValue(raw string) comparedValue {
+	n, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	finite := err == nil && !math.IsNaN(n) && !math.IsInf(n, 0)
+	return comparedValue{raw: raw, number: n, isNumber: finite, found: true}
+}
+
+// the min, max and mean of the numeric values of a key
+type keyStats struct {
+	count          int
+	min, max, mean float64
+}
+
+// a table of the values of some keys across versions
+type comparison struct {
+	keys     []string
+	versions []version
+	baseline int
+	values   [][]comparedValue
+	stats    []keyStats
+}
+
+// reads the values of the given keys in each version, keeping the files
+// read so far in cache
+func compareVersions(b Backend, versions []version, keys []string, baseline int) (c *comparison, err error) {
+	c = &comparison{keys: keys, versions: versions, baseline: baseline}
+	for i := range versions {
+		v := &versions[i]
+		files := map[string][]byte{}
+		row := []comparedValue{}
+		for _, k := range keys {
+			value, err := readKey(b, v, k, files)
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, value)
+		}
+		c.values = append(c.values, row)
+	}
+
+	for j := range keys {
+		s := keyStats{min: math.Inf(1), max: math.Inf(-1)}
+		sum := 0.0
+		for i := range versions {
+			if value := c.values[i][j]; value.isNumber {
+				s.count++
+				s.min = math.Min(s.min, value.number)
+				s.max = math.Max(s.max, value.number)
+				sum += value.number
+			}
+		}
+		if s.count > 0 {
+			s.mean = sum / float64(s.count)
+		}
+		c.stats = append(c.stats, s)
+	}
+	return
+}
+
+// reads a metadata key, or a field of a file of the snapshot if the key has
+// the form 'file.json:field' or 'file.csv:field'
+func readKey(b Backend, v *version, key string, files map[string][]byte) (value comparedValue, err error) {
+	if i := strings.Index(key, ":"); i > 0 {
+		path, field := key[:i], key[i+1:]
+		lower := strings.ToLower(path)
+		if strings.HasSuffix(lower, ".json") || strings.HasSuffix(lower, ".csv") {
+			contents, ok := files[path]
+			if !ok {
+				if contents, err = readFile(b, v, path); err != nil {
+					// not in this version
+					contents, err = nil, nil
+				}
+				files[path] = contents
+			}
+			if contents == nil {
+				return
+			}
+			raw, ok, err := readField(path, contents, field)
+			if err != nil || !ok {
+				return value, err
+			}
+			return newComparedValue(raw), nil
+		}
+	}
+	if raw, ok := v.meta.get(key); ok {
+		return newComparedValue(formatValue(raw)), nil
+	}
+	return
+}
+
+// selects a field of a JSON or CSV file. For JSON, the field is a path of
+// object keys and array indexes separated by dots, e.g. 'runs.0.latency'.
+// For CSV, it is the name of a column, whose value in the last row is
+// taken, or a row index and a column, e.g. '0.latency'.
+func readField(path string, contents []byte, field string) (raw string, ok bool, err error) {
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		node, ok, err := lookupJSON(path, contents, field)
+		if err != nil || !ok {
+			return "", false, err
+		}
+		raw, ok = jsonScalar(node)
+		return raw, ok, nil
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(contents)).ReadAll()
+	if err != nil {
+		return "", false, AnError{"Malformed CSV in " + path + ": " + err.Error()}
+	}
+	if len(records) < 2 {
+		return
+	}
+	row := len(records) - 1
+	column := field
+	if i := strings.Index(field, "."); i > 0 && csvColumn(records[0], field) < 0 {
+		if n, err := strconv.Atoi(field[:i]); err == nil {
+			row, column = n+1, field[i+1:]
+		}
+	}
+	j := csvColumn(records[0], column)
+	if j < 0 || row < 1 || row >= len(records) || j >= len(records[row]) {
+		return
+	}
+	return records[row][j], true, nil
+}
+
+// returns the node of a JSON document at a path of object keys and array
+// indexes separated by dots
+func lookupJSON(path string, contents []byte, field string) (node interface{}, ok bool, err error) {
+	if err = json.Unmarshal(contents, &node); err != nil {
+		return nil, false, AnError{"Malformed JSON in " + path + ": " + err.Error()}
+	}
+	for _, name := range strings.Split(field, ".") {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			node, ok = n[name]
+		case []interface{}:
+			i, err := strconv.Atoi(name)
+			ok = err == nil && i >= 0 && i < len(n)
+			if ok {
+				node = n[i]
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, false, nil
+		}
+	}
+	return node, true, nil
+}
+
+// formats a JSON value that is neither null, an object nor an array
+func jsonScalar(node interface{}) (string, bool) {
+	switch n := node.(type) {
+	case string:
+		return n, true
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64), true
+	case nil, map[string]interface{}, []interface{}:
+		return "", false
+	default:
+		return fmt.Sprintf("%v", n), true
+	}
+}
+
+func csvColumn(header []string, name string) int {
+	for j, h := range header {
+		if strings.TrimSpace(h) == name {
+			return j
+		}
+	}
+	return -1
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(math.Round(n*1e4)/1e4, 'f', -1, 64)
+}
+
+// the change of a value relative to the baseline, as a percentage. There
+// is none for the baseline itself.
+func (c *comparison) change(i int, j int) (pct float64, ok bool) {
+	base := c.values[c.baseline][j]
+	value := c.values[i][j]
+	if i == c.baseline || !base.isNumber || !value.isNumber || base.number == 0 {
+		return 0, false
+	}
+	return (value.number - base.number) / math.Abs(base.number) * 100, true
+}
+
+// a cell of the text and markdown tables
+func (c *comparison) cell(i int, j int) string {
+	value := c.values[i][j]
+	if !value.found {
+		return "-"
+	}
+	s := value.raw
+	if value.isNumber {
+		s = formatNumber(value.number)
+	}
+	if pct, ok := c.change(i, j); ok {
+		s += fmt.Sprintf(" (%+.1f%%)", pct)
+	}
+	return s
+}
+
+func (c *comparison) versionLabel(i int) string {
+	if i == c.baseline {
+		return c.versions[i].id() + " (baseline)"
+	}
+	return c.versions[i].id()
+}
+
+// rows of the text and markdown tables, starting with the header
+func (c *comparison) rows() (rows [][]string) {
+	rows = append(rows, append([]string{"version"}, c.keys...))
+	for i := range c.versions {
+		row := []string{c.versionLabel(i)}
+		for j := range c.keys {
+			row = append(row, c.cell(i, j))
+		}
+		rows = append(rows, row)
+	}
+	for _, stat := range []string{"min", "max", "mean"} {
+		row := []string{stat}
+		for _, s := range c.stats {
+			if s.count == 0 {
+				row = append(row, "-")
+				continue
+			}
+			row = append(row, formatNumber(map[string]float64{"min": s.min, "max": s.max, "mean": s.mean}[stat]))
+		}
+		rows = append(rows, row)
+	}
+	return
+}
+
+func (c *comparison) format(format string) (string, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "", "text":
+		w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		for _, row := range c.rows() {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		w.Flush()
+	case "markdown":
+		for i, row := range c.rows() {
+			buf.WriteString("| " + strings.Join(row, " | ") + " |\n")
+			if i == 0 {
+				buf.WriteString("|" + strings.Repeat(" --- |", len(row)) + "\n")
+			}
+		}
+	case "csv":
+		return c.formatCSV()
+	case "json":
+		return c.formatJSON()
+	default:
+		return "", AnError{"Unknown compare format '" + format + "'"}
+	}
+	return buf.String(), nil
+}
+
+// one row per version, with a column of values and one of changes per key,
+// followed by the min, max and mean rows
+func (c *comparison) formatCSV() (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	header := []string{"version", "baseline"}
+	for _, k := range c.keys {
+		header = append(header, k, k+" change %")
+	}
+	w.Write(header)
+	for i := range c.versions {
+		row := []string{c.versions[i].id(), strconv.FormatBool(i == c.baseline)}
+		for j := range c.keys {
+			row = append(row, c.values[i][j].raw)
+			if pct, ok := c.change(i, j); ok {
+				row = append(row, strconv.FormatFloat(pct, 'f', 2, 64))
+			} else {
+				row = append(row, "")
+			}
+		}
+		w.Write(row)
+	}
+	for _, stat := range []string{"min", "max", "mean"} {
+		row := []string{stat, ""}
+		for _, s := range c.stats {
+			if s.count == 0 {
+				row = append(row, "", "")
+				continue
+			}
+			row = append(row, formatNumber(map[string]float64{"min": s.min, "max": s.max, "mean": s.mean}[stat]), "")
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+// an entry of the JSON output of Compare
+type compareEntry struct {
+	Version string             `json:"version"`
+	Message string             `json:"message"`
+	Values  map[string]string  `json:"values"`
+	Changes map[string]float64 `json:"changes"`
+}
+
+type compareStats struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+}
+
+func (c *comparison) formatJSON() (string, error) {
+	out := struct {
+		Baseline string                  `json:"baseline"`
+		Keys     []string                `json:"keys"`
+		Versions []compareEntry          `json:"versions"`
+		Stats    map[string]compareStats `json:"stats"`
+	}{c.versions[c.baseline].id(), c.keys, []compareEntry{}, map[string]compareStats{}}
+
+	for i, v := range c.versions {
+		e := compareEntry{v.id(), v.meta.str("message"), map[string]string{}, map[string]float64{}}
+		for j, k := range c.keys {
+			if c.values[i][j].found {
+				e.Values[k] = c.values[i][j].raw
+			}
+			if pct, ok := c.change(i, j); ok {
+				e.Changes[k] = math.Round(pct*100) / 100
+			}
+		}
+		out.Versions = append(out.Versions, e)
+	}
+	for j, k := range c.keys {
+		if s := c.stats[j]; s.count > 0 {
+			out.Stats[k] = compareStats{s.min, s.max, s.mean}
+		}
+	}
+	contents, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(contents) + "\n", nil
+}
+
+// renders a table with the values of the given keys in the given versions
+// (all of them if none are given), along with their min, max and mean and
+// their change relative to the baseline version
+func Compare(refs []string, o CompareOptions) (table string, err error) {
+	if len(o.Keys) == 0 {
+		return "", AnError{"Expecting keys to compare"}
+	}
+	b, err := load()
+	if err != nil {
+		return
+	}
+	versions := []version{}
+	if len(refs) == 0 {
+		if versions, err = annotatedVersions(b); err != nil {
+			return
+		}
+	}
+	for _, ref := range refs {
+		v, err := resolveVersion(b, ref)
+		if err != nil {
+			return "", err
+		}
+		versions = append(versions, *v)
+	}
+	if len(versions) == 0 {
+		return "", AnError{"No versions to compare"}
+	}
+
+	baseline := 0
+	if o.Baseline != "" {
+		v, err := resolveVersion(b, o.Baseline)
+		if err != nil {
+			return "", err
+		}
+		baseline = -1
+		for i := range versions {
+			if versions[i].id() == v.id() {
+				baseline = i
+				break
+			}
+		}
+		if baseline < 0 {
+			versions = append([]version{*v}, versions...)
+			baseline = 0
+		}
+	}
+
+	c, err := compareVersions(b, versions, o.Keys, baseline)
+	if err != nil {
+		return
+	}
+	return c.format(o.Format)
+}
@@ -0,0 +1,107 @@
+package vio
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	assert.Nil(t, Init(".snapshots", "posix"))
+
+	runs := []struct{ threads, throughput, latency string }{
+		{"8", "100", "10"}, {"16", "150", "8"}, {"32", "200", "6.5"}}
+	for _, r := range runs {
+		assert.Nil(t, ioutil.WriteFile("results.json",
+			[]byte(`{"throughput": `+r.throughput+`, "runs": [{"ok": true}]}`), 0644))
+		assert.Nil(t, ioutil.WriteFile("stats.csv",
+			[]byte("step,latency\n0,99\n1,"+r.latency+"\n"), 0644))
+		assert.Nil(t, Commit("threads="+r.threads, `{"threads": "`+r.threads+`"}`))
+		time.Sleep(time.Second)
+	}
+
+	keys := []string{"threads", "results.json:throughput", "stats.csv:latency", "stats.csv:0.latency",
+		"results.json:runs.0.ok", "missing"}
+	table, err := Compare([]string{"@~2", "@~1", "@"}, CompareOptions{Keys: keys})
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(table), "\n")
+	assert.Equal(t, len(lines), 7)
+	assert.Equal(t, strings.Fields(lines[0]), append([]string{"version"}, keys...))
+	assert.Contains(t, lines[1], " (baseline)")
+	assert.Equal(t, strings.Fields(lines[1])[2:], []string{"8", "100", "10", "99", "true", "-"})
+	assert.Equal(t, strings.Fields(lines[3])[1:], []string{"32", "(+300.0%)", "200", "(+100.0%)",
+		"6.5", "(-35.0%)", "99", "(+0.0%)", "true", "-"})
+	assert.Equal(t, strings.Fields(lines[4]), []string{"min", "8", "100", "6.5", "99", "-", "-"})
+	assert.Equal(t, strings.Fields(lines[5]), []string{"max", "32", "200", "10", "99", "-", "-"})
+	assert.Equal(t, strings.Fields(lines[6]), []string{"mean", "18.6667", "150", "8.1667", "99", "-", "-"})
+
+	// a baseline that isn't among the compared versions is added
+	table, err = Compare([]string{"@"}, CompareOptions{
+		Keys: []string{"results.json:throughput"}, Baseline: "@~1", Format: "csv"})
+	assert.Nil(t, err)
+	lines = strings.Split(strings.TrimSpace(table), "\n")
+	assert.Equal(t, len(lines), 6)
+	assert.Equal(t, lines[0], "version,baseline,results.json:throughput,results.json:throughput change %")
+	assert.True(t, strings.HasSuffix(lines[1], ",true,150,"), lines[1])
+	assert.True(t, strings.HasSuffix(lines[2], ",false,200,33.33"), lines[2])
+	assert.Equal(t, lines[3:], []string{"min,,150,", "max,,200,", "mean,,175,"})
+
+	table, err = Compare(nil, CompareOptions{Keys: []string{"threads"}, Format: "markdown"})
+	assert.Nil(t, err)
+	lines = strings.Split(strings.TrimSpace(table), "\n")
+	assert.Equal(t, lines[0], "| version | threads |")
+	assert.Equal(t, lines[1], "| --- | --- |")
+	assert.Equal(t, len(lines), 8)
+
+	table, err = Compare([]string{"@~1", "@"}, CompareOptions{Keys: []string{"threads"}, Format: "json"})
+	assert.Nil(t, err)
+	var out struct {
+		Versions []struct {
+			Values  map[string]string
+			Changes map[string]float64
+		}
+		Stats map[string]struct{ Mean float64 }
+	}
+	assert.Nil(t, json.Unmarshal([]byte(table), &out))
+	assert.Equal(t, out.Versions[1].Values["threads"], "32")
+	assert.Equal(t, out.Versions[1].Changes["threads"], 100.0)
+	assert.Equal(t, out.Stats["threads"].Mean, 24.0)
+
+	_, err = Compare(nil, CompareOptions{})
+	assert.NotNil(t, err)
+	_, err = Compare(nil, CompareOptions{Keys: []string{"threads"}, Format: "xml"})
+	assert.NotNil(t, err)
+}
+
+func TestComparedValueNonFinite(t *testing.T) {
+	assert.True(t, newComparedValue(" 1.5").isNumber)
+	for _, raw := range []string{"NaN", "Inf", "-infinity", "1e400", "fast"} {
+		assert.False(t, newComparedValue(raw).isNumber, raw)
+	}
+
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	assert.Nil(t, Init(".snapshots", "posix"))
+	assert.Nil(t, Commit("first", `{"loss": "1"}`))
+	time.Sleep(time.Second)
+	assert.Nil(t, Commit("diverged", `{"loss": "NaN"}`))
+
+	table, err := Compare(nil, CompareOptions{Keys: []string{"loss"}, Format: "json"})
+	assert.Nil(t, err)
+	var out struct {
+		Versions []struct{ Values map[string]string }
+		Stats    map[string]struct{ Mean float64 }
+	}
+	assert.Nil(t, json.Unmarshal([]byte(table), &out))
+	assert.Equal(t, out.Versions[1].Values["loss"], "NaN")
+	assert.Equal(t, out.Stats["loss"].Mean, 1.0)
+}
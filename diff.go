@@ -0,0 +1,359 @@
+package vio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// number of unchanged lines shown around each change in a unified diff
+const diffContext = 3
+
+// files bigger than this are only summarized instead of being diffed
+const maxDiffSize = 1 << 20
+
+// files with more lines than this (in both versions together), or that
+// need more edits than maxDiffEdits, are only summarized as well, since
+// diffing them takes time proportional to lines times edits, and memory
+// proportional to the square of the edits
+const (
+	maxDiffLines = 20000
+	maxDiffEdits = 2000
+)
+
+// compares two versions of a backend, restricted to the given path (all
+// files if empty). The output lists added, removed and modified files,
+// followed by unified diffs for modified text files.
+func diffVersions(b Backend, v1 *version, v2 *version, path string) (out string, err error) {
+	files1, err := b.ListFiles(v1)
+	if err != nil {
+		return
+	}
+	files2, err := b.ListFiles(v2)
+	if err != nil {
+		return
+	}
+
+	before := filesByPath(files1, path)
+	after := filesByPath(files2, path)
+
+	paths := []string{}
+	for p := range before {
+		paths = append(paths, p)
+	}
+	for p := range after {
+		if _, ok := before[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var summary, patches bytes.Buffer
+	for _, p := range paths {
+		f1, inOld := before[p]
+		f2, inNew := after[p]
+
+		if !inOld {
+			fmt.Fprintf(&summary, "added:    %s (%d bytes)\n", p, f2.Size)
+			continue
+		}
+		if !inNew {
+			fmt.Fprintf(&summary, "removed:  %s (%d bytes)\n", p, f1.Size)
+			continue
+		}
+
+		c1, c2, err := readPair(b, v1, v2, f1, f2)
+		if err != nil {
+			return "", err
+		}
+		if c1 != nil && c2 != nil && bytes.Equal(c1, c2) {
+			if f1.Mode != f2.Mode {
+				fmt.Fprintf(&summary, "mode:     %s (%v -> %v)\n", p, f1.Mode, f2.Mode)
+			}
+			continue
+		}
+
+		fmt.Fprintf(&summary, "modified: %s\n", p)
+
+		if c1 == nil || c2 == nil || isBinary(c1) || isBinary(c2) {
+			fmt.Fprintf(&patches, "Binary files %s differ (%d bytes -> %d bytes)\n",
+				p, f1.Size, f2.Size)
+			continue
+		}
+		hunks, ok := unifiedDiff(splitLines(string(c1)), splitLines(string(c2)))
+		if !ok {
+			fmt.Fprintf(&patches, "Files %s differ too much to be diffed (%d bytes -> %d bytes)\n",
+				p, f1.Size, f2.Size)
+			continue
+		}
+		fmt.Fprintf(&patches, "--- a/%s\t%s#%d\n", p, v1.revision, v1.timestamp.Unix())
+		fmt.Fprintf(&patches, "+++ b/%s\t%s#%d\n", p, v2.revision, v2.timestamp.Unix())
+		patches.WriteString(hunks)
+	}
+
+	return summary.String() + patches.String(), nil
+}
+
+// indexes files by their path, keeping only those under the given prefix
+func filesByPath(files []FileInfo, prefix string) map[string]FileInfo {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "." {
+		prefix = ""
+	}
+	m := map[string]FileInfo{}
+	for _, f := range files {
+		if prefix != "" && f.Path != prefix && !strings.HasPrefix(f.Path, prefix+"/") {
+			continue
+		}
+		m[f.Path] = f
+	}
+	return m
+}
+
+// reads the contents of a file in two versions. Both contents are nil when
+// the files are too big to be diffed and differ.
+func readPair(b Backend, v1 *version, v2 *version,
+	f1 FileInfo, f2 FileInfo) (c1 []byte, c2 []byte, err error) {
+
+	if f1.Size > maxDiffSize || f2.Size > maxDiffSize {
+		if f1.Size != f2.Size {
+			return
+		}
+		var same bool
+		same, err = sameContents(b, v1, v2, f1.Path)
+		if err != nil || !same {
+			return
+		}
+		return []byte{}, []byte{}, nil
+	}
+
+	if c1, err = readFile(b, v1, f1.Path); err != nil {
+		return
+	}
+	c2, err = readFile(b, v2, f2.Path)
+	return
+}
+
+func readFile(b Backend, v *version, path string) ([]byte, error) {
+	r, err := b.OpenFile(v, path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// compares the contents of a file in two versions without loading them
+// entirely in memory
+func sameContents(b Backend, v1 *version, v2 *version, path string) (bool, error) {
+	r1, err := b.OpenFile(v1, path)
+	if err != nil {
+		return false, err
+	}
+	defer r1.Close()
+	r2, err := b.OpenFile(v2, path)
+	if err != nil {
+		return false, err
+	}
+	defer r2.Close()
+
+	buf1 := make([]byte, 32*1024)
+	buf2 := make([]byte, 32*1024)
+	for {
+		n1, err1 := io.ReadFull(r1, buf1)
+		n2, err2 := io.ReadFull(r2, buf2)
+		if n1 != n2 || !bytes.Equal(buf1[:n1], buf2[:n2]) {
+			return false, nil
+		}
+		if err1 == io.EOF || err1 == io.ErrUnexpectedEOF {
+			return err2 == io.EOF || err2 == io.ErrUnexpectedEOF, nil
+		}
+		if err1 != nil {
+			return false, err1
+		}
+		if err2 != nil {
+			return false, err2
+		}
+	}
+}
+
+// uses the same heuristic as git: a NUL byte in the first 8000 bytes
+func isBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// splits text into lines, keeping the line terminators
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+type diffOp struct {
+	kind byte // one of ' ', '-' or '+'
+	line string
+}
+
+// computes the shortest edit script between a and b using Myers' algorithm.
+// Returns false if the inputs are bigger than maxDiffLines or the script
+// would need more than maxDiffEdits edits.
+func diffLines(a []string, b []string) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	if n+m > maxDiffLines {
+		return nil, false
+	}
+	max := n + m
+	off := max + 1
+	v := make([]int, 2*max+3)
+	// the diagonals -d..d of v before each step d, which are the only ones
+	// that backtracking reads
+	trace := [][]int{}
+
+	d := 0
+found:
+	for ; d <= max; d++ {
+		if d > maxDiffEdits {
+			return nil, false
+		}
+		trace = append(trace, append([]int{}, v[off-d:off+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break found
+			}
+		}
+	}
+
+	ops := []diffOp{}
+	x, y := n, m
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[d+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			ops = append(ops, diffOp{'+', b[y-1]})
+			y--
+		} else {
+			ops = append(ops, diffOp{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, diffOp{' ', a[x-1]})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
+
+// renders the hunks of a unified diff between a and b. Returns false if
+// they are too big or too different to be diffed.
+func unifiedDiff(a []string, b []string) (string, bool) {
+	ops, ok := diffLines(a, b)
+	if !ok {
+		return "", false
+	}
+
+	var out bytes.Buffer
+	for start := 0; start < len(ops); {
+		// find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// extend the hunk while changes are close enough to each other
+		end := start
+		for i := start; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*diffContext {
+				break
+			}
+		}
+
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := end + diffContext
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		// line numbers where the hunk begins
+		aLine, bLine := 1, 1
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		aLen, bLen := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		if aLen == 0 {
+			aLine--
+		}
+		if bLen == 0 {
+			bLine--
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aLine, aLen, bLine, bLen)
+		for _, op := range ops[from:to] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		start = to
+	}
+	return out.String(), true
+}
@@ -0,0 +1,88 @@
+package vio
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitLines(t *testing.T) {
+	assert.Equal(t, splitLines(""), []string{})
+	assert.Equal(t, splitLines("a\nb\n"), []string{"a\n", "b\n"})
+	assert.Equal(t, splitLines("a\nb"), []string{"a\n", "b"})
+}
+
+func TestDiffLines(t *testing.T) {
+	ops, ok := diffLines([]string{"a", "b", "c"}, []string{"a", "c", "d"})
+	assert.True(t, ok)
+	assert.Equal(t, ops, []diffOp{
+		{' ', "a"}, {'-', "b"}, {' ', "c"}, {'+', "d"}})
+
+	ops, _ = diffLines([]string{}, []string{"a"})
+	assert.Equal(t, ops, []diffOp{{'+', "a"}})
+
+	ops, _ = diffLines([]string{"a"}, []string{})
+	assert.Equal(t, ops, []diffOp{{'-', "a"}})
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := splitLines("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n")
+	b := splitLines("1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\nsixteen")
+
+	hunks, ok := unifiedDiff(a, b)
+	assert.True(t, ok)
+	assert.Equal(t, hunks,
+		"@@ -1,6 +1,6 @@\n"+
+			" 1\n"+
+			" 2\n"+
+			"-3\n"+
+			"+three\n"+
+			" 4\n"+
+			" 5\n"+
+			" 6\n"+
+			"@@ -13,3 +13,4 @@\n"+
+			" 13\n"+
+			" 14\n"+
+			" 15\n"+
+			"+sixteen\n"+
+			"\\ No newline at end of file\n")
+
+	hunks, ok = unifiedDiff(a, a)
+	assert.True(t, ok)
+	assert.Equal(t, hunks, "")
+}
+
+// big inputs are diffed in bounded memory, or not at all
+func TestDiffLinesLimits(t *testing.T) {
+	lines := func(prefix string, n int) (l []string) {
+		for i := 0; i < n; i++ {
+			l = append(l, fmt.Sprintf("%s %d\n", prefix, i))
+		}
+		return
+	}
+
+	// few edits on many lines
+	a := lines("line", maxDiffLines/2-1)
+	b := append([]string{}, a...)
+	b[100] = "changed\n"
+	b = append(b, "appended\n")
+	ops, ok := diffLines(a, b)
+	assert.True(t, ok)
+	assert.Equal(t, len(ops), len(a)+2)
+
+	// too many lines
+	_, ok = diffLines(lines("line", maxDiffLines/2+1), lines("line", maxDiffLines/2))
+	assert.False(t, ok)
+
+	// too many edits
+	_, ok = diffLines(lines("old", 5000), lines("new", 5000))
+	assert.False(t, ok)
+	_, ok = unifiedDiff(lines("old", 5000), lines("new", 5000))
+	assert.False(t, ok)
+}
+
+func TestIsBinary(t *testing.T) {
+	assert.False(t, isBinary([]byte("hello\n")))
+	assert.True(t, isBinary([]byte("hel\x00lo")))
+}
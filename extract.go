@@ -0,0 +1,262 @@
+package vio
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// a rule that extracts a value from an output file into the metadata of a
+// version. Rules are sections of the configuration of the form:
+//
+//	[extract.throughput]
+//	file = results.json
+//	json = metrics.throughput
+//
+//	[extract.runtime]
+//	file = execution.out
+//	regex = elapsed: ([0-9.]+)s
+//	type = float
+//
+//	[extract.latency]
+//	file = stats.csv
+//	column = latency
+//	aggregate = mean
+//
+// where exactly one of 'json' (a path as in Compare), 'regex' (whose first
+// group is taken, or the whole match if it has none) and 'column' (of a
+// CSV file with a header) is given. When they select several values (every
+// match of the regex, every row of the column, or the elements of a JSON
+// array), 'aggregate' reduces them to one: first, last (the default), min,
+// max, mean, sum or count. The value is checked against 'type' (string,
+// int, float or bool), if given, and stored with that type in the key named
+// as the rule. Values without a type are stored as strings.
+type extractionRule struct {
+	name      string
+	file      string
+	json      string
+	regex     *regexp.Regexp
+	column    string
+	aggregate string
+	valueType string
+}
+
+var aggregates = map[string]bool{
+	"first": true, "last": true, "min": true, "max": true, "mean": true, "sum": true, "count": true}
+
+var valueTypes = map[string]bool{"": true, "string": true, "int": true, "float": true, "bool": true}
+
+func newExtractionRule(section *ini.Section) (r extractionRule, err error) {
+	// reading a missing key would add it to the section
+	value := func(key string, def string) string {
+		if !section.HasKey(key) {
+			return def
+		}
+		return section.Key(key).String()
+	}
+	sources := 0
+	for _, key := range []string{"json", "regex", "column"} {
+		if section.HasKey(key) {
+			sources++
+		}
+	}
+
+	r = extractionRule{
+		name:      strings.TrimPrefix(section.Name(), "extract."),
+		file:      value("file", ""),
+		json:      value("json", ""),
+		column:    value("column", ""),
+		aggregate: value("aggregate", "last"),
+		valueType: value("type", "")}
+
+	if r.file == "" {
+		return r, AnError{"Expecting key 'file' in extraction rule."}
+	}
+	if sources != 1 {
+		return r, AnError{"Expecting one of 'json', 'regex' or 'column' in extraction rule."}
+	}
+	if section.HasKey("regex") {
+		if r.regex, err = regexp.Compile(value("regex", "")); err != nil {
+			return r, AnError{"Malformed regex in extraction rule: " + err.Error()}
+		}
+	}
+	if !aggregates[r.aggregate] {
+		return r, AnError{"Unknown aggregate '" + r.aggregate + "' in extraction rule."}
+	}
+	if !valueTypes[r.valueType] {
+		return r, AnError{"Unknown type '" + r.valueType + "' in extraction rule."}
+	}
+	return
+}
+
+// selects the values of the rule in the contents of its file
+func (r extractionRule) values(contents []byte) (values []string, err error) {
+	switch {
+	case r.regex != nil:
+		for _, m := range r.regex.FindAllSubmatch(contents, -1) {
+			if len(m) > 1 {
+				values = append(values, string(m[1]))
+			} else {
+				values = append(values, string(m[0]))
+			}
+		}
+	case r.column != "":
+		records, err := csv.NewReader(bytes.NewReader(contents)).ReadAll()
+		if err != nil {
+			return nil, AnError{"Malformed CSV in " + r.file + ": " + err.Error()}
+		}
+		if len(records) == 0 || csvColumn(records[0], r.column) < 0 {
+			return nil, AnError{"No column '" + r.column + "' in " + r.file}
+		}
+		j := csvColumn(records[0], r.column)
+		for _, record := range records[1:] {
+			if j < len(record) {
+				values = append(values, record[j])
+			}
+		}
+	default:
+		node, ok, err := lookupJSON(r.file, contents, r.json)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, AnError{"No '" + r.json + "' in " + r.file}
+		}
+		nodes := []interface{}{node}
+		if array, ok := node.([]interface{}); ok {
+			nodes = array
+		}
+		for _, n := range nodes {
+			if s, ok := jsonScalar(n); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return
+}
+
+// reduces the values selected by a rule to one
+func (r extractionRule) reduce(values []string) (string, error) {
+	if r.aggregate == "count" {
+		return strconv.Itoa(len(values)), nil
+	}
+	if len(values) == 0 {
+		return "", AnError{"No value found in " + r.file}
+	}
+	switch r.aggregate {
+	case "first":
+		return strings.TrimSpace(values[0]), nil
+	case "last":
+		return strings.TrimSpace(values[len(values)-1]), nil
+	}
+
+	numbers := []float64{}
+	for _, v := range values {
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return "", AnError{"Can't aggregate '" + v + "', it isn't a number"}
+		}
+		numbers = append(numbers, n)
+	}
+	min, max, sum := math.Inf(1), math.Inf(-1), 0.0
+	for _, n := range numbers {
+		min = math.Min(min, n)
+		max = math.Max(max, n)
+		sum += n
+	}
+	result := map[string]float64{
+		"min": min, "max": max, "sum": sum, "mean": sum / float64(len(numbers))}[r.aggregate]
+	return strconv.FormatFloat(result, 'f', -1, 64), nil
+}
+
+// converts a value to the type of the rule: numbers are stored as such
+// (as json.Number), booleans as bool, and anything else as a string
+func (r extractionRule) convert(value string) (interface{}, error) {
+	switch r.valueType {
+	case "int":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, AnError{"'" + value + "' is not an int"}
+		}
+		return json.Number(strconv.FormatInt(n, 10)), nil
+	case "float":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+			return nil, AnError{"'" + value + "' is not a float"}
+		}
+		return json.Number(strconv.FormatFloat(n, 'f', -1, 64)), nil
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, AnError{"'" + value + "' is not a bool"}
+		}
+		return b, nil
+	}
+	return value, nil
+}
+
+// extracts the value of a rule from its file, relative to the given folder
+func (r extractionRule) extract(dir string) (value interface{}, err error) {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, r.file))
+	if err != nil {
+		return "", AnError{"Can't read " + r.file + ": " + err.Error()}
+	}
+	values, err := r.values(contents)
+	if err != nil {
+		return
+	}
+	reduced, err := r.reduce(values)
+	if err != nil {
+		return
+	}
+	return r.convert(reduced)
+}
+
+// the extraction rules declared in the configuration, sorted by name
+func extractionRules(opts *ini.File) (rules []extractionRule, err error) {
+	for _, section := range opts.Sections() {
+		if !strings.HasPrefix(section.Name(), "extract.") {
+			continue
+		}
+		r, err := newExtractionRule(section)
+		if err != nil {
+			return nil, AnError{"[" + section.Name() + "]: " + err.Error()}
+		}
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].name < rules[j].name })
+	return
+}
+
+// adds the values extracted by the rules of the configuration from the
+// files in the given folder to the metadata of a version. Keys given by the
+// user are never overwritten. Like collectors, rules that fail don't
+// prevent the commit; their error is stored in '<name>.error' instead.
+// Malformed rules do, so that they are noticed.
+func addExtracted(meta metadata, opts *ini.File, dir string) (err error) {
+	rules, err := extractionRules(opts)
+	if err != nil {
+		return
+	}
+	for _, r := range rules {
+		if _, ok := meta[r.name]; ok {
+			continue
+		}
+		value, err := r.extract(dir)
+		if err != nil {
+			meta[r.name+".error"] = err.Error()
+			continue
+		}
+		meta[r.name] = value
+	}
+	return
+}
@@ -0,0 +1,119 @@
+package vio
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gopkg.in/ini.v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddExtracted(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(path+"/results.json",
+		[]byte(`{"metrics": {"throughput": 1.5e3, "ok": true, "runs": [3, 4, 8]}}`), 0644))
+	assert.Nil(t, ioutil.WriteFile(path+"/execution.out",
+		[]byte("iteration 1 took 2.5s\niteration 2 took 3.5s\ndone\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(path+"/stats.csv",
+		[]byte("step,latency\n0,10\n1,20\n2,30\n"), 0644))
+
+	opts, err := ini.Load([]byte(`
+[extract.throughput]
+file = results.json
+json = metrics.throughput
+type = int
+
+[extract.ok]
+file = results.json
+json = metrics.ok
+type = bool
+
+[extract.runs]
+file = results.json
+json = metrics.runs
+aggregate = max
+
+[extract.last_iteration]
+file = execution.out
+regex = took ([0-9.]+)s
+
+[extract.iterations]
+file = execution.out
+regex = iteration \d+
+aggregate = count
+
+[extract.latency]
+file = stats.csv
+column = latency
+aggregate = mean
+
+[extract.given]
+file = stats.csv
+column = latency
+
+[extract.missing]
+file = missing.json
+json = foo
+
+[extract.mistyped]
+file = execution.out
+regex = done
+type = float
+`))
+	assert.Nil(t, err)
+
+	meta := metadata{"given": "by the user"}
+	assert.Nil(t, addExtracted(meta, opts, path))
+	assert.Equal(t, meta["throughput"], json.Number("1500"))
+	assert.Equal(t, meta["ok"], true)
+	assert.Equal(t, meta["runs"], "8")
+	assert.Equal(t, meta["last_iteration"], "3.5")
+	assert.Equal(t, meta["iterations"], "2")
+	assert.Equal(t, meta["latency"], "20")
+	assert.Equal(t, meta["given"], "by the user")
+	assert.Contains(t, meta["missing.error"], "Can't read missing.json")
+	assert.Equal(t, meta["mistyped.error"], "'done' is not a float")
+	_, ok := meta["missing"]
+	assert.False(t, ok)
+
+	for _, malformed := range []string{
+		"[extract.a]\njson = foo",
+		"[extract.a]\nfile = a.json",
+		"[extract.a]\nfile = a.json\njson = foo\ncolumn = bar",
+		"[extract.a]\nfile = a.out\nregex = (",
+		"[extract.a]\nfile = a.csv\ncolumn = a\naggregate = median",
+		"[extract.a]\nfile = a.csv\ncolumn = a\ntype = date",
+	} {
+		opts, err := ini.Load([]byte(malformed))
+		assert.Nil(t, err)
+		assert.NotNil(t, addExtracted(metadata{}, opts, path), malformed)
+	}
+}
+
+func TestCommitExtracts(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	assert.Nil(t, Init(".snapshots", "posix"))
+
+	f, err := os.OpenFile(".vioconfig", os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteString("\n[extract.runtime]\nfile = execution.out\nregex = elapsed: ([0-9.]+)s\ntype = float\n")
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	_, err = Run("with extraction", "{}", []string{"sh", "-c", "echo 'elapsed: 1.25s' > execution.out"})
+	assert.Nil(t, err)
+
+	b, err := load()
+	assert.Nil(t, err)
+	vs, err := b.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(vs), 1)
+	assert.Equal(t, vs[0].meta["runtime"], json.Number("1.25"))
+}
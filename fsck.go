@@ -0,0 +1,211 @@
+package vio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// a problem found when checking a repository
+type problem struct {
+	msg string
+
+	// whether repairing fixes it
+	fixable bool
+
+	// whether it is expected, such as the data of versions removed with
+	// 'vio rm', which 'vio gc' removes
+	warning bool
+
+	// whether it is a snapshot missing from the index, which is repaired by
+	// reindexing
+	unindexed bool
+}
+
+// the problem of a snapshot, manifest or ref found in storage but not in the
+// index. It's only expected if its version was removed with 'vio rm';
+// otherwise the index lost it, and reindexing adds it back.
+func unindexedProblem(what string, path string, removed map[string]bool) problem {
+	if collectable(path, removed) {
+		return problem{msg: what + " " + path + " is not in the index, 'vio gc' removes it", warning: true}
+	}
+	return problem{msg: what + " " + path + " is not in the index", fixable: true, unindexed: true}
+}
+
+// checks the lines of an index. Malformed lines, and those of versions
+// whose snapshot is missing according to stored, are reported and left out
+// of the fixed index, which keeps the format of the original one.
+func checkIndex(contents []byte, stored func(v *version) (bool, error)) (
+	versions []version, fixed []byte, problems []problem, err error) {
+
+	idx, err := decodeIndex(contents)
+	if err != nil {
+		return
+	}
+	kept := []indexEntry{}
+	for _, e := range idx.entries {
+		if e.v == nil {
+			problems = append(problems, problem{msg: "malformed index line: " + e.line, fixable: true})
+			continue
+		}
+		ok, err := stored(e.v)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if !ok {
+			problems = append(problems, problem{msg: "version " + e.v.id() + ": snapshot is missing", fixable: true})
+			continue
+		}
+		versions = append(versions, *e.v)
+		kept = append(kept, e)
+	}
+	idx.entries = kept
+	if fixed, err = idx.encode(); err != nil {
+		return
+	}
+	return versions, fixed, problems, nil
+}
+
+// checks the index of the backends that keep it in the local filesystem,
+// replacing it by its fixed version if repair is true. The original is
+// kept in '<snapshots>/index.bak'. The caller has to hold the index lock.
+func fsckIndexFile(snapsPath string, stored func(v *version) (bool, error),
+	repair bool) (versions []version, problems []problem, err error) {
+
+	contents, err := ioutil.ReadFile(snapsPath + "/index")
+	if err != nil {
+		return
+	}
+	versions, fixed, problems, err := checkIndex(contents, stored)
+	if err != nil || !repair || len(problems) == 0 {
+		return
+	}
+	if err = ioutil.WriteFile(snapsPath+"/index.bak", contents, 0644); err != nil {
+		return
+	}
+	err = ioutil.WriteFile(snapsPath+"/index", fixed, 0644)
+	return
+}
+
+// whether a file exists in the local filesystem
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// compares the manifest of a version with the actual files of its snapshot
+func compareManifests(v *version, expected []manifestEntry, actual []manifestEntry) (problems []problem) {
+	found := map[string]manifestEntry{}
+	for _, e := range actual {
+		found[e.Path] = e
+	}
+	for _, e := range expected {
+		a, ok := found[e.Path]
+		switch {
+		case !ok:
+			problems = append(problems, problem{msg: "version " + v.id() + ": file " + e.Path + " is missing"})
+		case a.Digest != e.Digest:
+			problems = append(problems, problem{msg: "version " + v.id() + ": file " + e.Path + " doesn't match its checksum"})
+		case a.Mode != e.Mode:
+			problems = append(problems, problem{msg: fmt.Sprintf("version %s: mode of file %s changed from %v to %v",
+				v.id(), e.Path, e.Mode, a.Mode)})
+		}
+		delete(found, e.Path)
+	}
+	extra := []string{}
+	for path := range found {
+		extra = append(extra, path)
+	}
+	sort.Strings(extra)
+	for _, path := range extra {
+		problems = append(problems, problem{msg: "version " + v.id() + ": file " + path + " is not in its manifest"})
+	}
+	return
+}
+
+// checks that the objects in which the files of a version are stored match
+// their digest. Results are cached in checked, indexed by digest.
+func verifyObjects(v *version, manifest []manifestEntry,
+	open func(digest string) (io.ReadCloser, error), checked map[string]string) (problems []problem) {
+
+	for _, e := range manifest {
+		result, ok := checked[e.Digest]
+		if !ok {
+			result = verifyObject(e.Digest, open)
+			checked[e.Digest] = result
+		}
+		if result != "" {
+			problems = append(problems, problem{msg: "version " + v.id() + ": file " + e.Path + " " + result})
+		}
+	}
+	return
+}
+
+func verifyObject(digest string, open func(digest string) (io.ReadCloser, error)) string {
+	r, err := open(digest)
+	if err != nil {
+		return "is missing (" + err.Error() + ")"
+	}
+	actual, _, err := digestOf(r)
+	r.Close()
+	if err != nil {
+		return "can't be read (" + err.Error() + ")"
+	}
+	if actual != digest {
+		return "doesn't match its checksum"
+	}
+	return ""
+}
+
+// checks the integrity of the repository: that every line of the index is
+// well-formed and refers to a stored snapshot, that every stored snapshot is
+// in the index, and that the files of each snapshot match their checksums.
+// With repair, the problems that can be fixed are fixed. Returns an error if
+// problems remain.
+func Fsck(repair bool) (report string, err error) {
+	b, err := load()
+	if err != nil {
+		return
+	}
+	problems, err := b.Fsck(repair)
+	if err != nil {
+		return
+	}
+	if repair {
+		for _, p := range problems {
+			if p.unindexed {
+				if _, err = b.Reindex(false); err != nil {
+					return
+				}
+				break
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	unfixed := 0
+	for _, p := range problems {
+		switch {
+		case p.warning:
+			buf.WriteString("warning: " + p.msg + "\n")
+		case p.fixable && repair:
+			buf.WriteString("repaired: " + p.msg + "\n")
+		case p.fixable:
+			buf.WriteString("error: " + p.msg + " (--repair fixes it)\n")
+			unfixed++
+		default:
+			buf.WriteString("error: " + p.msg + "\n")
+			unfixed++
+		}
+	}
+	if unfixed > 0 {
+		return buf.String(), AnError{fmt.Sprintf("%d problems found", unfixed)}
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,160 @@
+package vio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createFsckTestRepo(t *testing.T, backend string) (versions []version) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	assert.Nil(t, Init(".snapshots", backend))
+
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte("1"), 0644))
+	assert.Nil(t, Commit("first", "{}"))
+	time.Sleep(time.Second)
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte("2"), 0644))
+	assert.Nil(t, Commit("second", "{}"))
+
+	report, err := Fsck(false)
+	assert.Nil(t, err)
+	assert.Equal(t, report, "")
+
+	b, err := load()
+	assert.Nil(t, err)
+	versions, err = b.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(versions), 2)
+	return
+}
+
+// breaks the index with a malformed line and a line of a missing snapshot
+func testFsckIndex(t *testing.T) {
+	f, err := os.OpenFile(".snapshots/index", os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteString("not a version line\n{\"version\":\"1234567#1000000000\",\"meta\":{}}\n")
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	report, err := Fsck(false)
+	assert.NotNil(t, err)
+	assert.Contains(t, report, "error: malformed index line: not a version line (--repair fixes it)\n")
+	assert.Contains(t, report, "error: version 1234567#1000000000: snapshot is missing (--repair fixes it)\n")
+
+	report, err = Fsck(true)
+	assert.Nil(t, err)
+	assert.Contains(t, report, "repaired: malformed index line: not a version line\n")
+	backup, err := ioutil.ReadFile(".snapshots/index.bak")
+	assert.Nil(t, err)
+	assert.Contains(t, string(backup), "not a version line")
+
+	report, err = Fsck(false)
+	assert.Nil(t, err)
+	assert.Equal(t, report, "")
+	logstr, err := Log()
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Count(logstr, "\n"), 2)
+}
+
+// loses the line of a version, which reindexing recovers
+func testFsckLostIndexLine(t *testing.T) {
+	original, err := ioutil.ReadFile(".snapshots/index")
+	assert.Nil(t, err)
+	lines := strings.SplitAfter(string(original), "\n")
+	assert.Nil(t, ioutil.WriteFile(".snapshots/index", []byte(strings.Join(lines[:len(lines)-2], "")), 0644))
+
+	report, err := Fsck(false)
+	assert.NotNil(t, err)
+	assert.Contains(t, report, " is not in the index (--repair fixes it)\n")
+	assert.False(t, strings.Contains(report, "vio gc"))
+
+	report, err = Fsck(true)
+	assert.Nil(t, err)
+	assert.Contains(t, report, " is not in the index\n")
+	assert.True(t, strings.HasPrefix(report, "repaired: "))
+	restored, err := ioutil.ReadFile(".snapshots/index")
+	assert.Nil(t, err)
+	assert.Equal(t, string(restored), string(original))
+
+	report, err = Fsck(false)
+	assert.Nil(t, err)
+	assert.Equal(t, report, "")
+}
+
+func TestPosixFsck(t *testing.T) {
+	versions := createFsckTestRepo(t, "posix")
+	testFsckIndex(t)
+	testFsckLostIndexLine(t)
+
+	b, err := load()
+	assert.Nil(t, err)
+	posix := b.(*PosixBackend)
+	snap := posix.snapshotPath(&versions[0])
+	assert.Nil(t, os.Chmod(snap+"/results.txt", 0644))
+	assert.Nil(t, ioutil.WriteFile(snap+"/results.txt", []byte("tampered"), 0644))
+	assert.Nil(t, ioutil.WriteFile(snap+"/extra.txt", []byte("extra"), 0644))
+
+	report, err := Fsck(true)
+	assert.NotNil(t, err)
+	assert.Contains(t, report, "error: version "+versions[0].id()+": file results.txt doesn't match its checksum\n")
+	assert.Contains(t, report, "error: version "+versions[0].id()+": file extra.txt is not in its manifest\n")
+
+	// snapshots committed before checksums were recorded
+	assert.Nil(t, os.Remove(posix.manifestPath(&versions[1])))
+	report, err = Fsck(false)
+	assert.NotNil(t, err)
+	assert.Contains(t, report, "error: version "+versions[1].id()+": no checksums recorded (--repair fixes it)\n")
+	report, err = Fsck(true)
+	assert.Contains(t, report, "repaired: version "+versions[1].id()+": no checksums recorded\n")
+	_, err = os.Stat(posix.manifestPath(&versions[1]))
+	assert.Nil(t, err)
+
+	// removed versions are only reported until collected
+	assert.Nil(t, Remove([]string{versions[1].id()}))
+	report, err = Fsck(false)
+	assert.NotNil(t, err)
+	assert.Contains(t, report, fmt.Sprintf("warning: snapshot %s is not in the index", posix.snapshotPath(&versions[1])))
+}
+
+func TestCasFsck(t *testing.T) {
+	versions := createFsckTestRepo(t, "cas")
+	testFsckIndex(t)
+	testFsckLostIndexLine(t)
+
+	b, err := load()
+	assert.Nil(t, err)
+	cas := b.(*CasBackend)
+	manifest, err := cas.readManifest(&versions[0])
+	assert.Nil(t, err)
+	object := cas.objectPath(manifest[len(manifest)-1].Digest)
+	assert.Nil(t, os.Chmod(object, 0644))
+	assert.Nil(t, ioutil.WriteFile(object, []byte("tampered"), 0644))
+
+	report, err := Fsck(true)
+	assert.NotNil(t, err)
+	assert.Contains(t, report, "error: version "+versions[0].id()+": file "+manifest[len(manifest)-1].Path+
+		" doesn't match its checksum\n")
+
+	assert.Nil(t, os.Remove(object))
+	report, err = Fsck(false)
+	assert.NotNil(t, err)
+	assert.Contains(t, report, manifest[len(manifest)-1].Path+" is missing")
+}
+
+func TestGitFsck(t *testing.T) {
+	versions := createFsckTestRepo(t, "git")
+	testFsckIndex(t)
+	testFsckLostIndexLine(t)
+
+	assert.Nil(t, Remove([]string{versions[1].id()}))
+	report, err := Fsck(false)
+	assert.Nil(t, err)
+	assert.Contains(t, report, "warning: ref refs/vio/")
+}
@@ -0,0 +1,132 @@
+package vio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// removes the files of a content-addressable store whose name is not one of
+// the referenced digests, which includes the temporary files left behind by
+// interrupted commits. Returns the removed files and their total size.
+func gcObjects(root string, referenced map[string]bool,
+	dryRun bool) (removed []string, freed int64, err error) {
+
+	if _, err = os.Stat(root); os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || referenced[info.Name()] {
+			return nil
+		}
+		removed = append(removed, path)
+		freed += info.Size()
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	})
+	return
+}
+
+// returns the space used by the files of a folder, not counting files that
+// are hardlinked from somewhere else, since removing the folder doesn't free
+// them
+func exclusiveSize(root string) (size int64, err error) {
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && linkCount(info) <= 1 {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// removes versions from the index, along with the tags that refer to them.
+// The files of the versions are removed by GC.
+func Remove(refs []string) (err error) {
+	b, err := load()
+	if err != nil {
+		return
+	}
+	vs := []*version{}
+	for _, ref := range refs {
+		v, err := resolveVersion(b, ref)
+		if err != nil {
+			return err
+		}
+		vs = append(vs, v)
+	}
+	if err = b.Remove(vs); err != nil {
+		return
+	}
+
+	tags, err := b.GetTags()
+	if err != nil {
+		return
+	}
+	byVersion := tagsByVersion(tags)
+	for _, v := range vs {
+		for _, name := range byVersion[v.id()] {
+			if err = b.DeleteTag(name); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// removes the data of the versions removed from the index, and the stored
+// files that no version refers to. With dryRun, only reports what would be
+// removed.
+func GC(dryRun bool) (report string, err error) {
+	b, err := load()
+	if err != nil {
+		return
+	}
+	removed, freed, err := b.GC(dryRun)
+	if err != nil {
+		return
+	}
+
+	verb, summary := "removed", "%d bytes freed\n"
+	if dryRun {
+		verb, summary = "would remove", "%d bytes would be freed\n"
+	}
+	for _, r := range removed {
+		report += verb + " " + r + "\n"
+	}
+	return report + fmt.Sprintf(summary, freed), nil
+}
+
+// removes the empty folders below root, but not root itself
+func removeEmptyDirs(root string) error {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		path := root + "/" + e.Name()
+		if err = removeEmptyDirs(path); err != nil {
+			return err
+		}
+		if left, err := ioutil.ReadDir(path); err != nil {
+			return err
+		} else if len(left) == 0 {
+			if err = os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
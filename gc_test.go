@@ -0,0 +1,137 @@
+package vio
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testGC(t *testing.T, backend string) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	err = Init(".snapshots", backend)
+	assert.Nil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte(strings.Repeat("1", 1000)), 0644))
+	assert.Nil(t, Commit("first", "{}"))
+	time.Sleep(time.Second)
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte(strings.Repeat("2", 1000)), 0644))
+	assert.Nil(t, Commit("second", "{}"))
+	time.Sleep(time.Second)
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte(strings.Repeat("3", 1000)), 0644))
+	assert.Nil(t, Commit("third", "{}"))
+
+	assert.Nil(t, Tag("baseline", "@~1", false))
+	assert.Nil(t, Tag("final", "@", false))
+
+	// nothing to collect yet
+	report, err := GC(true)
+	assert.Nil(t, err)
+	assert.Equal(t, report, "0 bytes would be freed\n")
+
+	// all references are resolved before removing anything
+	assert.NotNil(t, Remove([]string{"@~1", "no-such-version"}))
+	logstr, err := Log()
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Count(logstr, "\n"), 3)
+
+	assert.Nil(t, Remove([]string{"baseline", "@~2"}))
+	logstr, err = Log()
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Count(logstr, "\n"), 1)
+	assert.Contains(t, logstr, " (final) third\n")
+	tagstr, err := ListTags()
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(tagstr, "baseline"))
+
+	report, err = GC(true)
+	assert.Nil(t, err)
+	assert.Contains(t, report, "would remove ")
+	freed := strings.Split(strings.TrimSpace(report), "\n")
+	summary := freed[len(freed)-1]
+	assert.True(t, strings.HasSuffix(summary, " bytes would be freed"))
+	assert.NotEqual(t, summary, "0 bytes would be freed")
+
+	report, err = GC(false)
+	assert.Nil(t, err)
+	assert.Contains(t, report, "removed ")
+	assert.True(t, strings.HasSuffix(report, " bytes freed\n"))
+
+	report, err = GC(true)
+	assert.Nil(t, err)
+	assert.Equal(t, report, "0 bytes would be freed\n")
+
+	assert.Nil(t, os.Remove("results.txt"))
+	assert.Nil(t, Checkout("final"))
+	contents, err := ioutil.ReadFile("results.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), strings.Repeat("3", 1000))
+
+	showstr, err := Show("@", false)
+	assert.Nil(t, err)
+	assert.Contains(t, showstr, " results.txt\n")
+
+	// a version missing from a corrupted index isn't collected
+	time.Sleep(time.Second)
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte(strings.Repeat("4", 1000)), 0644))
+	assert.Nil(t, Commit("fourth", "{}"))
+	index, err := ioutil.ReadFile(".snapshots/index")
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(index)), "\n")
+	lines[len(lines)-1] = lines[len(lines)-1][:20]
+	assert.Nil(t, ioutil.WriteFile(".snapshots/index", []byte(strings.Join(lines, "\n")+"\n"), 0644))
+	logstr, err = Log()
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(logstr, "fourth"))
+
+	report, err = GC(false)
+	assert.Nil(t, err)
+	assert.Equal(t, report, "0 bytes freed\n")
+
+	_, err = Reindex(false)
+	assert.Nil(t, err)
+	logstr, err = Log()
+	assert.Nil(t, err)
+	assert.Contains(t, logstr, " fourth\n")
+	assert.Nil(t, os.Remove("results.txt"))
+	assert.Nil(t, Checkout("@"))
+	contents, err = ioutil.ReadFile("results.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), strings.Repeat("4", 1000))
+}
+
+func TestGCPosix(t *testing.T) {
+	testGC(t, "posix")
+	_, err := os.Stat(".snapshots")
+	assert.Nil(t, err)
+}
+
+func TestGCGit(t *testing.T) {
+	testGC(t, "git")
+
+	// the contents of removed versions are pruned, even though the
+	// remaining version was committed on top of them
+	for _, c := range []string{"1", "2"} {
+		cmd := exec.Command("git", "hash-object", "--stdin")
+		cmd.Stdin = strings.NewReader(strings.Repeat(c, 1000))
+		blob, err := cmd.Output()
+		assert.Nil(t, err)
+		err = exec.Command("git", "--git-dir", ".snapshots/git", "cat-file", "-e",
+			strings.TrimSpace(string(blob))).Run()
+		assert.NotNil(t, err)
+	}
+}
+
+func TestGCGitLfs(t *testing.T) {
+	testGC(t, "git-lfs")
+}
+
+func TestGCCas(t *testing.T) {
+	testGC(t, "cas")
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package vio
+
+import (
+	"os"
+	"syscall"
+)
+
+// returns the number of hardlinks to a file
+func linkCount(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
@@ -0,0 +1,8 @@
+package vio
+
+import "os"
+
+// returns the number of hardlinks to a file
+func linkCount(info os.FileInfo) uint64 {
+	return 1
+}
@@ -0,0 +1,743 @@
+package vio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// GitBackend stores each snapshot as a commit in a bare git repository that
+// lives in the snapshots folder. Commits are referenced by refs of the form
+// 'refs/vio/<revision>/<timestamp>' and snapshots of the same revision are
+// chained together, so 'git log refs/vio/<revision>/<timestamp>' shows the
+// history of executions for a revision.
+type GitBackend struct {
+	snapshotsPath string
+	repoPath      string
+
+	// whether file contents are stored as git-lfs objects
+	lfs bool
+}
+
+func NewGitBackend(o *ini.File) (b Backend, err error) {
+	if !o.Section("").HasKey("snapshots_path") {
+		return nil, AnError{"Expecting key 'snapshots_path' in configuration."}
+	}
+	if !o.Section("").HasKey("repo_path") {
+		return nil, AnError{"Expecting key 'repo_path' in configuration."}
+	}
+	return &GitBackend{
+		snapshotsPath: o.Section("").Key("snapshots_path").String(),
+		repoPath:      o.Section("").Key("repo_path").String()}, nil
+}
+
+func (b GitBackend) gitDir() string {
+	return b.snapshotsPath + "/git"
+}
+
+func (b GitBackend) ref(v *version) string {
+	return fmt.Sprintf("refs/vio/%s/%d", v.revision, v.timestamp.Unix())
+}
+
+// runs a git command against the snapshots repository, using the project
+// repo as working tree
+func (b GitBackend) git(env []string, stdin io.Reader, args ...string) (string, error) {
+	gitDir, err := filepath.Abs(b.gitDir())
+	if err != nil {
+		return "", err
+	}
+	workTree, err := filepath.Abs(b.repoPath)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = workTree
+	cmd.Env = append(os.Environ(), "GIT_DIR="+gitDir, "GIT_WORK_TREE="+workTree)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", AnError{"git " + args[0] + " failed: " + strings.TrimSpace(stderr.String())}
+	}
+	return string(out), nil
+}
+
+// returns the path to a temporary git index that has to be removed by the
+// caller. Using a separate index allows to stage and checkout files in the
+// project repo without interfering with its own index.
+func (b GitBackend) tempIndex() (string, error) {
+	f, err := ioutil.TempFile(b.gitDir(), "vio-index-")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	name, err := filepath.Abs(f.Name())
+	if err != nil {
+		return "", err
+	}
+	// git refuses to read an empty index file
+	return name, os.Remove(name)
+}
+
+func (b GitBackend) Init() (err error) {
+	if err = os.Mkdir(b.snapshotsPath, 0755); err != nil {
+		return
+	}
+
+	if _, err = os.Stat(b.snapshotsPath + "/index"); err == nil {
+		return AnError{"Repository already initialized"}
+	}
+
+	if _, err = exec.Command("git", "init", "--bare", "--quiet", b.gitDir()).Output(); err != nil {
+		return
+	}
+
+	if b.lfs {
+		if err = os.MkdirAll(b.lfsObjectsPath(), 0755); err != nil {
+			return
+		}
+	}
+
+	if err = ioutil.WriteFile(b.snapshotsPath+"/index", emptyIndex(), 0644); err != nil {
+		return
+	}
+
+	return
+}
+
+func (b GitBackend) Open() error {
+	return nil
+}
+
+func (b GitBackend) IsInitialized() bool {
+	if _, err := os.Stat(b.gitDir()); err != nil {
+		return false
+	}
+	_, err := os.Stat(b.snapshotsPath + "/index")
+	return err == nil
+}
+
+func (b GitBackend) GetStatus() (Status, error) {
+	return stagingStatus(b.repoPath)
+}
+
+func (b GitBackend) Checkout(v *version) (err error) {
+	if err = checkRepo(b, b.repoPath); err != nil {
+		return
+	}
+
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	if err = checkInIndex(b, v); err != nil {
+		return
+	}
+
+	idxFile, err := b.tempIndex()
+	if err != nil {
+		return
+	}
+	defer os.Remove(idxFile)
+
+	env := []string{"GIT_INDEX_FILE=" + idxFile}
+	if _, err = b.git(env, nil, "read-tree", b.ref(v)); err != nil {
+		return
+	}
+	if b.lfs {
+		return b.checkoutLfs(v, env)
+	}
+	_, err = b.git(env, nil, "checkout-index", "--all", "--force")
+	return
+}
+
+func (b GitBackend) Commit(meta metadata) (v *version, err error) {
+	if err = checkCommit(b, b.repoPath, meta); err != nil {
+		return
+	}
+	versionedFiles, err := GetVersionedFiles(b.repoPath)
+	if err != nil {
+		return
+	}
+
+	id, err := GetCurrentCommitId(b.repoPath)
+	if err != nil {
+		return
+	}
+
+	v = NewVersionWithMeta(id, meta)
+
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	idx, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+	if ContainsVersion(idx, v) {
+		return nil, AnError{"Version " + fmt.Sprintf("%v", v) + " already in index."}
+	}
+
+	files, err := filesToCommit(b.repoPath, b.snapshotsPath, versionedFiles)
+	if err != nil {
+		return
+	}
+
+	tree, err := b.writeTree(files)
+	if err != nil {
+		return
+	}
+
+	parent, err := b.latestCommit(v.revision)
+	if err != nil {
+		return
+	}
+
+	commit, err := b.commitTree(v, tree, parent)
+	if err != nil {
+		return
+	}
+
+	if _, err = b.git(nil, nil, "update-ref", b.ref(v), commit); err != nil {
+		return
+	}
+
+	if err = addVersionToIndex(v, b.snapshotsPath+"/index"); err != nil {
+		return
+	}
+
+	err = clearStaged(b.repoPath)
+	return
+}
+
+// adds the given files of the project repo to the snapshots repository and
+// returns the id of the tree that contains them
+func (b GitBackend) writeTree(files []string) (tree string, err error) {
+	idxFile, err := b.tempIndex()
+	if err != nil {
+		return
+	}
+	defer os.Remove(idxFile)
+
+	env := []string{"GIT_INDEX_FILE=" + idxFile}
+	if b.lfs {
+		err = b.addLfsFiles(env, files)
+	} else {
+		paths := strings.NewReader(strings.Join(files, "\x00"))
+		_, err = b.git(env, paths, "update-index", "--add", "-z", "--stdin")
+	}
+	if err != nil {
+		return
+	}
+
+	out, err := b.git(env, nil, "write-tree")
+	if err != nil {
+		return
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// returns the commit of the latest snapshot of a revision, or an empty
+// string if there's none
+func (b GitBackend) latestCommit(revision string) (commit string, err error) {
+	out, err := b.git(nil, nil, "for-each-ref", "--count=1",
+		"--sort=-committerdate", "--format=%(objectname)", "refs/vio/"+revision+"/")
+	return strings.TrimSpace(out), err
+}
+
+// creates a commit for a version. The parent is the commit of the previous
+// snapshot of the same revision, if any.
+func (b GitBackend) commitTree(v *version, tree string, parent string) (commit string, err error) {
+	metaJSON, err := json.Marshal(v.meta)
+	if err != nil {
+		return
+	}
+	msg := fmt.Sprintf("%s#%d %s\n\n%s\n",
+		v.revision, v.timestamp.Unix(), strings.TrimSpace(v.meta.str("message")), metaJSON)
+
+	date := fmt.Sprintf("%d +0000", v.timestamp.Unix())
+	env := []string{
+		"GIT_AUTHOR_NAME=vio", "GIT_AUTHOR_EMAIL=vio@localhost", "GIT_AUTHOR_DATE=" + date,
+		"GIT_COMMITTER_NAME=vio", "GIT_COMMITTER_EMAIL=vio@localhost", "GIT_COMMITTER_DATE=" + date}
+
+	args := []string{"commit-tree", tree}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	out, err := b.git(env, strings.NewReader(msg), args...)
+	if err != nil {
+		return
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (b GitBackend) GetVersions() (versions []version, err error) {
+	return readIndex(b.snapshotsPath + "/index")
+}
+
+func (b GitBackend) GetTags() (map[string]string, error) {
+	return readTagsFile(b.snapshotsPath)
+}
+
+func (b GitBackend) SetTag(name string, v *version) error {
+	return updateTagsFile(b.snapshotsPath, func(tags map[string]string) error {
+		return addTag(tags, name, v)
+	})
+}
+
+func (b GitBackend) DeleteTag(name string) error {
+	return updateTagsFile(b.snapshotsPath, func(tags map[string]string) error {
+		return removeTag(tags, name)
+	})
+}
+
+func (b GitBackend) GetAnnotations() ([]annotation, error) {
+	return readAnnotationsFile(b.snapshotsPath)
+}
+
+func (b GitBackend) Annotate(a annotation) error {
+	return appendAnnotationFile(b.snapshotsPath, a)
+}
+
+func (b GitBackend) Remove(vs []*version) error {
+	return removeFromIndexFile(b.snapshotsPath+"/index", vs)
+}
+
+// removes the refs of versions removed from the index and prunes the
+// objects that only they refer to. Since the snapshots of a revision are
+// chained together, the remaining snapshots of the revisions that lose one
+// are committed again without it in their history; otherwise, its objects
+// would still be reachable.
+func (b GitBackend) GC(dryRun bool) (removed []string, freed int64, err error) {
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	idx, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+	removedVersions, err := readRemovedFile(b.snapshotsPath)
+	if err != nil {
+		return
+	}
+	// versions missing from the index that weren't removed are kept, along
+	// with the metadata in their commits
+	stored, err := b.refVersions()
+	if err != nil {
+		return
+	}
+	for _, v := range stored {
+		if !ContainsVersion(idx, &v) && !collectable(b.ref(&v), removedVersions) {
+			if v.meta == nil {
+				v.meta = metadata{}
+			}
+			idx = append(idx, v)
+		}
+	}
+	kept := map[string]bool{}
+	for i := range idx {
+		kept[b.ref(&idx[i])] = true
+	}
+
+	out, err := b.git(nil, nil, "for-each-ref", "--format=%(refname) %(objectname) %(tree)", "refs/vio/")
+	if err != nil {
+		return
+	}
+	trees := map[string]string{}
+	var orphans, orphanObjects, keptTrees []string
+	affected := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		ref, commit, tree := fields[0], fields[1], fields[2]
+		trees[ref] = tree
+		if kept[ref] {
+			keptTrees = append(keptTrees, tree)
+			continue
+		}
+		orphans = append(orphans, ref)
+		orphanObjects = append(orphanObjects, commit, tree)
+		affected[strings.Split(strings.TrimPrefix(ref, "refs/vio/"), "/")[0]] = true
+	}
+
+	removed = append(removed, orphans...)
+	if len(orphans) > 0 {
+		if freed, err = b.unsharedSize(orphanObjects, keptTrees); err != nil {
+			return
+		}
+	}
+
+	if b.lfs {
+		lfsRemoved, lfsFreed, err := b.gcLfs(idx, dryRun)
+		if err != nil {
+			return nil, 0, err
+		}
+		removed = append(removed, lfsRemoved...)
+		freed += lfsFreed
+	}
+
+	if dryRun || len(orphans) == 0 {
+		return
+	}
+
+	var deletes bytes.Buffer
+	for _, ref := range orphans {
+		fmt.Fprintf(&deletes, "delete %s\n", ref)
+	}
+	if _, err = b.git(nil, &deletes, "update-ref", "--stdin"); err != nil {
+		return
+	}
+
+	sort.SliceStable(idx, func(i, j int) bool { return idx[i].timestamp.Before(idx[j].timestamp) })
+	parents := map[string]string{}
+	for i := range idx {
+		v := &idx[i]
+		tree, ok := trees[b.ref(v)]
+		if !affected[v.revision] || !ok {
+			continue
+		}
+		commit, err := b.commitTree(v, tree, parents[v.revision])
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err = b.git(nil, nil, "update-ref", b.ref(v), commit); err != nil {
+			return nil, 0, err
+		}
+		parents[v.revision] = commit
+	}
+
+	if _, err = b.git(nil, nil, "reflog", "expire", "--expire=now", "--all"); err != nil {
+		return
+	}
+	_, err = b.git(nil, nil, "gc", "--prune=now", "--quiet")
+	return
+}
+
+// returns the space taken by the given objects, and those reachable from
+// them, that are not reachable from the given trees
+func (b GitBackend) unsharedSize(objects []string, trees []string) (size int64, err error) {
+	args := append([]string{"rev-list", "--objects", "--no-walk"}, objects...)
+	if len(trees) > 0 {
+		args = append(append(args, "--not"), trees...)
+	}
+	out, err := b.git(nil, nil, args...)
+	if err != nil {
+		return
+	}
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			ids = append(ids, fields[0])
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	out, err = b.git(nil, strings.NewReader(strings.Join(ids, "\n")+"\n"),
+		"cat-file", "--batch-check=%(objectsize:disk)")
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Fields(out) {
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return 0, AnError{"Unexpected cat-file output: " + line}
+		}
+		size += n
+	}
+	return
+}
+
+func (b GitBackend) Import(v *version, dir string) (err error) {
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	idx, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+	if ContainsVersion(idx, v) {
+		return AnError{"Version " + v.id() + " already in index."}
+	}
+
+	files, err := listDir(dir)
+	if err != nil {
+		return
+	}
+
+	// the files are added from dir instead of the project repo
+	src := b
+	if src.repoPath, err = filepath.Abs(dir); err != nil {
+		return
+	}
+	tree, err := src.writeTree(files)
+	if err != nil {
+		return
+	}
+	parent, err := b.latestCommit(v.revision)
+	if err != nil {
+		return
+	}
+	commit, err := b.commitTree(v, tree, parent)
+	if err != nil {
+		return
+	}
+	if _, err = b.git(nil, nil, "update-ref", b.ref(v), commit); err != nil {
+		return
+	}
+	return addVersionToIndex(v, b.snapshotsPath+"/index")
+}
+
+func (b GitBackend) Diff(v1 *version, v2 *version, obj string) (string, error) {
+	if err := checkInIndex(b, v1, v2); err != nil {
+		return "", err
+	}
+	return diffVersions(b, v1, v2, obj)
+}
+
+// an entry of a git tree
+type treeEntry struct {
+	mode   string
+	object string
+	size   int64
+	path   string
+}
+
+// lists the files in the tree of a version
+func (b GitBackend) lsTree(v *version) (entries []treeEntry, err error) {
+	out, err := b.git(nil, nil, "ls-tree", "-r", "-l", "-z", b.ref(v))
+	if err != nil {
+		return
+	}
+
+	entries = []treeEntry{}
+	for _, entry := range strings.Split(out, "\x00") {
+		// <mode> <type> <object> <size>\t<path>
+		i := strings.Index(entry, "\t")
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(entry[:i])
+		if len(fields) != 4 {
+			return nil, AnError{"Unexpected ls-tree output: " + entry}
+		}
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, treeEntry{fields[0], fields[2], size, entry[i+1:]})
+	}
+	return
+}
+
+func (b GitBackend) ListFiles(v *version) (files []FileInfo, err error) {
+	entries, err := b.lsTree(v)
+	if err != nil {
+		return
+	}
+
+	var pointers map[string]lfsPointer
+	if b.lfs {
+		if pointers, err = b.readLfsPointers(entries); err != nil {
+			return
+		}
+	}
+
+	files = []FileInfo{}
+	for _, e := range entries {
+		size := e.size
+		if p, ok := pointers[e.path]; ok {
+			size = p.size
+		}
+		files = append(files, FileInfo{
+			Path: e.path,
+			Size: size,
+			Mode: gitFileMode(e.mode)})
+	}
+	return
+}
+
+func gitFileMode(mode string) os.FileMode {
+	switch mode {
+	case "100755":
+		return 0755
+	case "120000":
+		return os.ModeSymlink | 0777
+	default:
+		return 0644
+	}
+}
+
+func (b GitBackend) OpenFile(v *version, path string) (io.ReadCloser, error) {
+	object := b.ref(v) + ":" + path
+	if _, err := b.git(nil, nil, "cat-file", "-e", object); err != nil {
+		return nil, AnError{"File " + path + " not in version " + v.revision}
+	}
+
+	if b.lfs {
+		return b.openLfsFile(object)
+	}
+
+	gitDir, err := filepath.Abs(b.gitDir())
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("git", "cat-file", "blob", object)
+	cmd.Env = append(os.Environ(), "GIT_DIR="+gitDir)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdReader{stdout, cmd}, nil
+}
+
+// reads the output of a command, waiting for it to finish when closed
+type cmdReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *cmdReader) Close() error {
+	r.ReadCloser.Close()
+	return r.cmd.Wait()
+}
+
+// checks the index against the refs of the snapshots repository, runs 'git
+// fsck' on it and, with LFS, verifies the stored objects
+func (b GitBackend) Fsck(repair bool) (problems []problem, err error) {
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	out, err := b.git(nil, nil, "for-each-ref", "--format=%(refname)", "refs/vio/")
+	if err != nil {
+		return
+	}
+	refs := map[string]bool{}
+	for _, ref := range strings.Fields(out) {
+		refs[ref] = true
+	}
+
+	versions, problems, err := fsckIndexFile(b.snapshotsPath, func(v *version) (bool, error) {
+		return refs[b.ref(v)], nil
+	}, repair)
+	if err != nil {
+		return
+	}
+
+	if _, err := b.git(nil, nil, "fsck", "--no-dangling", "--no-progress"); err != nil {
+		problems = append(problems, problem{msg: err.Error()})
+	}
+
+	kept := map[string]bool{}
+	checked := map[string]string{}
+	open := func(oid string) (io.ReadCloser, error) {
+		return openObject(b.lfsObjectPath(oid))
+	}
+	for i := range versions {
+		v := &versions[i]
+		kept[b.ref(v)] = true
+		if !b.lfs {
+			continue
+		}
+		entries, err := b.lsTree(v)
+		if err != nil {
+			return nil, err
+		}
+		pointers, err := b.readLfsPointers(entries)
+		if err != nil {
+			return nil, err
+		}
+		manifest := []manifestEntry{}
+		for path, p := range pointers {
+			manifest = append(manifest, manifestEntry{Path: path, Digest: p.oid, Size: p.size})
+		}
+		sort.Slice(manifest, func(i, j int) bool { return manifest[i].Path < manifest[j].Path })
+		problems = append(problems, verifyObjects(v, manifest, open, checked)...)
+	}
+
+	removed, err := readRemovedFile(b.snapshotsPath)
+	if err != nil {
+		return
+	}
+	sorted := []string{}
+	for ref := range refs {
+		if !kept[ref] {
+			sorted = append(sorted, ref)
+		}
+	}
+	sort.Strings(sorted)
+	for _, ref := range sorted {
+		problems = append(problems, unindexedProblem("ref", ref, removed))
+	}
+	return
+}
+
+// returns the versions that have a ref in the snapshots repository. The
+// metadata of each version is the last line of the message of its commit,
+// which can have more lines before it if the message given has several.
+func (b GitBackend) refVersions() (found []version, err error) {
+	out, err := b.git(nil, nil, "for-each-ref", "--format=%(refname)%00%(contents:body)%00%00", "refs/vio/")
+	if err != nil {
+		return
+	}
+	for _, record := range strings.Split(out, "\x00\x00\n") {
+		fields := strings.SplitN(record, "\x00", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		v, ok := versionOfPath(fields[0])
+		if !ok {
+			continue
+		}
+		body := strings.Split(strings.TrimSpace(fields[1]), "\n")
+		if last := strings.TrimSpace(body[len(body)-1]); last != "" {
+			v.meta = parseSidecar([]byte(v.id()+","+last), v)
+		}
+		found = append(found, *v)
+	}
+	return
+}
+
+// rebuilds the index from the refs of the snapshots repository
+func (b GitBackend) Reindex(dryRun bool) ([]version, error) {
+	return reindexFile(b.snapshotsPath, b.refVersions, dryRun)
+}
+
+// migrates the index to the current format
+func (b GitBackend) UpgradeIndex() (int, error) {
+	return upgradeIndexFile(b.snapshotsPath)
+}
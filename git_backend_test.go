@@ -0,0 +1,210 @@
+package vio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"gopkg.in/ini.v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getNewGitBackend(t *testing.T, path string) (b Backend) {
+	opts := ini.Empty()
+	assert.NotNil(t, opts)
+
+	opts.Section("").Key("repo_path").SetValue(path)
+	opts.Section("").Key("snapshots_path").SetValue(path + "/.snapshots")
+	opts.Section("").Key("backend_type").SetValue("git")
+
+	b, err := InstantiateBackend(opts)
+	assert.NotNil(t, b)
+	assert.Nil(t, err)
+
+	return
+}
+
+func TestGitBackendInit(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewGitBackend(t, path)
+
+	assert.False(t, backend.IsInitialized())
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	assert.True(t, backend.IsInitialized())
+
+	_, err = os.Stat(path + "/.snapshots/index")
+	assert.Nil(t, err)
+	_, err = os.Stat(path + "/.snapshots/git/HEAD")
+	assert.Nil(t, err)
+}
+
+func TestGitBackendCommit(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewGitBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("yeah"), 0644)
+	assert.Nil(t, err)
+	err = os.Mkdir(path+"/folder", 0755)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/folder/toz", []byte("ok"), 0755)
+	assert.Nil(t, err)
+
+	v, err := backend.Commit(metadata{"message": "first"})
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+
+	files, err := backend.ListFiles(v)
+	assert.Nil(t, err)
+	assert.Equal(t, files, []FileInfo{
+		{Path: "bar", Size: 4, Mode: 0644},
+		{Path: "folder/toz", Size: 2, Mode: 0755}})
+
+	contents, err := readFile(backend, v, "folder/toz")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "ok")
+
+	_, err = backend.OpenFile(v, "README")
+	assert.NotNil(t, err)
+
+	out, err := exec.Command("git", "--git-dir="+path+"/.snapshots/git",
+		"log", "--format=%s", fmt.Sprintf("refs/vio/%s/%d", v.revision, v.timestamp.Unix())).Output()
+	assert.Nil(t, err)
+	assert.Equal(t, string(out), fmt.Sprintf("%s#%d first\n", v.revision, v.timestamp.Unix()))
+
+	contents, err = ioutil.ReadFile(path + "/.snapshots/index")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), indexOf(t, v))
+}
+
+func TestGitBackendCommitWithIgnore(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewGitBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("yeah"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/.vioignore", []byte("ignored_folder\n"), 0644)
+	assert.Nil(t, err)
+	err = os.Mkdir(path+"/ignored_folder", 0755)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/ignored_folder/foo", []byte("ignore this\n"), 0644)
+	assert.Nil(t, err)
+	_, err = runCmd(path, "git add .vioignore")
+	assert.Nil(t, err)
+	_, err = runCmd(path, "git commit -m committing_vio_ignored_files")
+	assert.Nil(t, err)
+
+	v, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+
+	files, err := backend.ListFiles(v)
+	assert.Nil(t, err)
+	assert.Equal(t, len(files), 1)
+	assert.Equal(t, files[0].Path, "bar")
+}
+
+func TestGitBackendCheckout(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewGitBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("yeah"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/toz", []byte("ok"), 0644)
+	assert.Nil(t, err)
+
+	v, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+
+	err = os.Remove(path + "/bar")
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/toz", []byte("changed"), 0644)
+	assert.Nil(t, err)
+
+	err = backend.Checkout(v)
+	assert.Nil(t, err)
+
+	contents, err := ioutil.ReadFile(path + "/bar")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "yeah")
+	contents, err = ioutil.ReadFile(path + "/toz")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "ok")
+
+	// the project repo should be left untouched
+	has, err := HasUncommittedChanges(path)
+	assert.Nil(t, err)
+	assert.False(t, has)
+
+	err = backend.Checkout(NewVersion("1234567890#1405544146"))
+	assert.NotNil(t, err)
+}
+
+func TestGitBackendDiff(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewGitBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("one\ntwo\n"), 0644)
+	assert.Nil(t, err)
+
+	v1, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("one\n2\n"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/toz", []byte("ok"), 0644)
+	assert.Nil(t, err)
+
+	time.Sleep(time.Second)
+
+	v2, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+
+	d, err := backend.Diff(v1, v2, "")
+	assert.Nil(t, err)
+	assert.Contains(t, d, "modified: bar\n")
+	assert.Contains(t, d, "added:    toz (2 bytes)\n")
+	assert.Contains(t, d, "@@ -1,2 +1,2 @@\n one\n-two\n+2\n")
+
+	vs, err := backend.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(vs), 2)
+}
@@ -0,0 +1,280 @@
+package vio
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// NewGitLfsBackend returns a GitBackend that keeps the tree and metadata of
+// snapshots in git, but stores file contents as git-lfs objects. Objects
+// live in '<snapshots>/git/lfs/objects', the same layout used by git-lfs,
+// so no LFS server is needed and the repository can be used offline.
+func NewGitLfsBackend(o *ini.File) (b Backend, err error) {
+	b, err = NewGitBackend(o)
+	if err != nil {
+		return
+	}
+	b.(*GitBackend).lfs = true
+	return
+}
+
+const lfsSpec = "https://git-lfs.github.com/spec/v1"
+
+// pointers are tiny, anything bigger than this is not a pointer
+const maxLfsPointerSize = 1024
+
+// the content of the blob that git stores instead of a file
+type lfsPointer struct {
+	oid  string
+	size int64
+}
+
+func (p lfsPointer) String() string {
+	return fmt.Sprintf("version %s\noid sha256:%s\nsize %d\n", lfsSpec, p.oid, p.size)
+}
+
+func parseLfsPointer(content []byte) (p lfsPointer, ok bool) {
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) < 3 || lines[0] != "version "+lfsSpec {
+		return
+	}
+	for _, line := range lines[1:] {
+		if strings.HasPrefix(line, "oid sha256:") {
+			p.oid = strings.TrimPrefix(line, "oid sha256:")
+		} else if strings.HasPrefix(line, "size ") {
+			size, err := strconv.ParseInt(strings.TrimPrefix(line, "size "), 10, 64)
+			if err != nil {
+				return
+			}
+			p.size = size
+		}
+	}
+	return p, len(p.oid) == sha256.Size*2
+}
+
+func (b GitBackend) lfsObjectsPath() string {
+	return b.gitDir() + "/lfs/objects"
+}
+
+func (b GitBackend) lfsObjectPath(oid string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", b.lfsObjectsPath(), oid[0:2], oid[2:4], oid)
+}
+
+// copies a file into the LFS object store, unless an object with the same
+// contents is already there
+func (b GitBackend) storeLfsObject(path string) (p lfsPointer, err error) {
+	p.oid, p.size, err = storeObject(path, b.lfsObjectsPath(), b.lfsObjectPath)
+	return
+}
+
+// stores the given files of the project repo as LFS objects and adds their
+// pointers to the git index given in env. Symlinks are added as regular git
+// blobs.
+func (b GitBackend) addLfsFiles(env []string, files []string) (err error) {
+	pointersDir, err := ioutil.TempDir(b.gitDir(), "vio-pointers-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(pointersDir)
+
+	var paths, modes, pointerFiles, links []string
+	for i, file := range files {
+		info, err := os.Lstat(b.repoPath + "/" + file)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			links = append(links, file)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		p, err := b.storeLfsObject(b.repoPath + "/" + file)
+		if err != nil {
+			return err
+		}
+		pointerFile, err := filepath.Abs(fmt.Sprintf("%s/%d", pointersDir, i))
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(pointerFile, []byte(p.String()), 0644); err != nil {
+			return err
+		}
+
+		mode := "100644"
+		if info.Mode()&0111 != 0 {
+			mode = "100755"
+		}
+		paths = append(paths, file)
+		modes = append(modes, mode)
+		pointerFiles = append(pointerFiles, pointerFile)
+	}
+
+	if len(paths) > 0 {
+		out, err := b.git(nil, strings.NewReader(strings.Join(pointerFiles, "\n")),
+			"hash-object", "-w", "--no-filters", "--stdin-paths")
+		if err != nil {
+			return err
+		}
+		blobs := strings.Fields(out)
+		if len(blobs) != len(paths) {
+			return AnError{"Unexpected hash-object output: " + out}
+		}
+
+		var indexInfo bytes.Buffer
+		for i := range paths {
+			fmt.Fprintf(&indexInfo, "%s %s\t%s\x00", modes[i], blobs[i], paths[i])
+		}
+		if _, err = b.git(env, &indexInfo, "update-index", "--add", "-z", "--index-info"); err != nil {
+			return err
+		}
+	}
+
+	if len(links) > 0 {
+		_, err = b.git(env, strings.NewReader(strings.Join(links, "\x00")),
+			"update-index", "--add", "-z", "--stdin")
+	}
+
+	return
+}
+
+// reads the LFS pointers among the given tree entries, indexed by path
+func (b GitBackend) readLfsPointers(entries []treeEntry) (pointers map[string]lfsPointer, err error) {
+	pointers = map[string]lfsPointer{}
+
+	var objects, paths []string
+	for _, e := range entries {
+		if e.mode == "120000" || e.size > maxLfsPointerSize {
+			continue
+		}
+		objects = append(objects, e.object)
+		paths = append(paths, e.path)
+	}
+	if len(objects) == 0 {
+		return
+	}
+
+	contents, err := b.catBlobs(objects)
+	if err != nil {
+		return
+	}
+	for i, content := range contents {
+		if p, ok := parseLfsPointer(content); ok {
+			pointers[paths[i]] = p
+		}
+	}
+	return
+}
+
+// reads several blobs using a single git process
+func (b GitBackend) catBlobs(objects []string) (contents [][]byte, err error) {
+	out, err := b.git(nil, strings.NewReader(strings.Join(objects, "\n")+"\n"),
+		"cat-file", "--batch")
+	if err != nil {
+		return
+	}
+
+	// each blob is printed as '<object> <type> <size>\n<contents>\n'
+	r := bufio.NewReader(strings.NewReader(out))
+	for range objects {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return nil, AnError{"Unexpected cat-file output: " + header}
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		content := make([]byte, size+1)
+		if _, err = io.ReadFull(r, content); err != nil {
+			return nil, err
+		}
+		contents = append(contents, content[:size])
+	}
+	return
+}
+
+func (b GitBackend) openLfsFile(object string) (io.ReadCloser, error) {
+	out, err := b.git(nil, nil, "cat-file", "blob", object)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := parseLfsPointer([]byte(out))
+	if !ok {
+		// a symlink
+		return ioutil.NopCloser(strings.NewReader(out)), nil
+	}
+	return openObject(b.lfsObjectPath(p.oid))
+}
+
+// writes the files of a version in the project repo, replacing pointers by
+// the contents of their LFS objects. The tree of the version has to be read
+// in the git index given in env.
+func (b GitBackend) checkoutLfs(v *version, env []string) (err error) {
+	entries, err := b.lsTree(v)
+	if err != nil {
+		return
+	}
+	pointers, err := b.readLfsPointers(entries)
+	if err != nil {
+		return
+	}
+
+	var others []string
+	for _, e := range entries {
+		p, ok := pointers[e.path]
+		if !ok {
+			others = append(others, e.path)
+			continue
+		}
+		if err = b.smudge(p, b.repoPath+"/"+e.path, gitFileMode(e.mode)); err != nil {
+			return
+		}
+	}
+
+	if len(others) > 0 {
+		_, err = b.git(env, strings.NewReader(strings.Join(others, "\x00")),
+			"checkout-index", "--force", "-z", "--stdin")
+	}
+	return
+}
+
+// copies the LFS object of a pointer to the given path
+func (b GitBackend) smudge(p lfsPointer, path string, mode os.FileMode) error {
+	return restoreObject(b.lfsObjectPath(p.oid), path, mode)
+}
+
+// removes the LFS objects that none of the given versions refers to
+func (b GitBackend) gcLfs(versions []version, dryRun bool) (removed []string, freed int64, err error) {
+	referenced := map[string]bool{}
+	for i := range versions {
+		entries, err := b.lsTree(&versions[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		pointers, err := b.readLfsPointers(entries)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, p := range pointers {
+			referenced[p.oid] = true
+		}
+	}
+	return gcObjects(b.lfsObjectsPath(), referenced, dryRun)
+}
@@ -0,0 +1,244 @@
+package vio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/ini.v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getNewGitLfsBackend(t *testing.T, path string) (b Backend) {
+	opts := ini.Empty()
+	assert.NotNil(t, opts)
+
+	opts.Section("").Key("repo_path").SetValue(path)
+	opts.Section("").Key("snapshots_path").SetValue(path + "/.snapshots")
+	opts.Section("").Key("backend_type").SetValue("git-lfs")
+
+	b, err := InstantiateBackend(opts)
+	assert.NotNil(t, b)
+	assert.Nil(t, err)
+
+	return
+}
+
+func countLfsObjects(t *testing.T, path string) (n int) {
+	err := filepath.Walk(path+"/.snapshots/git/lfs/objects",
+		func(p string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				n++
+			}
+			return err
+		})
+	assert.Nil(t, err)
+	return
+}
+
+func TestLfsPointer(t *testing.T) {
+	p := lfsPointer{
+		oid:  "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393",
+		size: 12345}
+	assert.Equal(t, p.String(),
+		"version https://git-lfs.github.com/spec/v1\n"+
+			"oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\n"+
+			"size 12345\n")
+
+	parsed, ok := parseLfsPointer([]byte(p.String()))
+	assert.True(t, ok)
+	assert.Equal(t, parsed, p)
+
+	_, ok = parseLfsPointer([]byte("not a pointer\n"))
+	assert.False(t, ok)
+}
+
+func TestGitLfsBackendInit(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewGitLfsBackend(t, path)
+
+	assert.False(t, backend.IsInitialized())
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	assert.True(t, backend.IsInitialized())
+
+	_, err = os.Stat(path + "/.snapshots/index")
+	assert.Nil(t, err)
+	_, err = os.Stat(path + "/.snapshots/git/lfs/objects")
+	assert.Nil(t, err)
+}
+
+func TestGitLfsBackendCommit(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewGitLfsBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("yeah"), 0644)
+	assert.Nil(t, err)
+	err = os.Mkdir(path+"/folder", 0755)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/folder/toz", []byte("ok"), 0755)
+	assert.Nil(t, err)
+	err = os.Symlink("bar", path+"/link")
+	assert.Nil(t, err)
+
+	v, err := backend.Commit(metadata{"message": "first"})
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+
+	files, err := backend.ListFiles(v)
+	assert.Nil(t, err)
+	assert.Equal(t, files, []FileInfo{
+		{Path: "bar", Size: 4, Mode: 0644},
+		{Path: "folder/toz", Size: 2, Mode: 0755},
+		{Path: "link", Size: 3, Mode: os.ModeSymlink | 0777}})
+
+	contents, err := readFile(backend, v, "bar")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "yeah")
+
+	// git only has the pointer
+	out, err := exec.Command("git", "--git-dir="+path+"/.snapshots/git", "cat-file", "blob",
+		fmt.Sprintf("refs/vio/%s/%d:bar", v.revision, v.timestamp.Unix())).Output()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "version https://git-lfs.github.com/spec/v1\n"))
+
+	assert.Equal(t, countLfsObjects(t, path), 2)
+
+	contents, err = ioutil.ReadFile(path + "/.snapshots/index")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), indexOf(t, v))
+}
+
+func TestGitLfsBackendCommitWithIgnore(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewGitLfsBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("yeah"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/.vioignore", []byte("ignored_folder\n"), 0644)
+	assert.Nil(t, err)
+	err = os.Mkdir(path+"/ignored_folder", 0755)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/ignored_folder/foo", []byte("ignore this\n"), 0644)
+	assert.Nil(t, err)
+	_, err = runCmd(path, "git add .vioignore")
+	assert.Nil(t, err)
+	_, err = runCmd(path, "git commit -m committing_vio_ignored_files")
+	assert.Nil(t, err)
+
+	v, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+
+	files, err := backend.ListFiles(v)
+	assert.Nil(t, err)
+	assert.Equal(t, len(files), 1)
+	assert.Equal(t, files[0].Path, "bar")
+}
+
+func TestGitLfsBackendCheckout(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewGitLfsBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("yeah"), 0644)
+	assert.Nil(t, err)
+	err = os.Mkdir(path+"/folder", 0755)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/folder/toz", []byte("ok"), 0755)
+	assert.Nil(t, err)
+	err = os.Symlink("bar", path+"/link")
+	assert.Nil(t, err)
+
+	v, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+	assert.NotNil(t, v)
+
+	err = os.Remove(path + "/bar")
+	assert.Nil(t, err)
+	err = os.RemoveAll(path + "/folder")
+	assert.Nil(t, err)
+	err = os.Remove(path + "/link")
+	assert.Nil(t, err)
+
+	err = backend.Checkout(v)
+	assert.Nil(t, err)
+
+	contents, err := ioutil.ReadFile(path + "/bar")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "yeah")
+	info, err := os.Stat(path + "/folder/toz")
+	assert.Nil(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0755))
+	target, err := os.Readlink(path + "/link")
+	assert.Nil(t, err)
+	assert.Equal(t, target, "bar")
+}
+
+func TestGitLfsBackendDedup(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewGitLfsBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/input", []byte("a large dataset"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/output", []byte("one\ntwo\n"), 0644)
+	assert.Nil(t, err)
+
+	v1, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+	assert.Equal(t, countLfsObjects(t, path), 2)
+
+	err = ioutil.WriteFile(path+"/output", []byte("one\n2\n"), 0644)
+	assert.Nil(t, err)
+
+	time.Sleep(time.Second)
+
+	v2, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+	assert.Equal(t, countLfsObjects(t, path), 3)
+
+	d, err := backend.Diff(v1, v2, "")
+	assert.Nil(t, err)
+	assert.Equal(t, d, "modified: output\n"+
+		fmt.Sprintf("--- a/output\t%s#%d\n", v1.revision, v1.timestamp.Unix())+
+		fmt.Sprintf("+++ b/output\t%s#%d\n", v2.revision, v2.timestamp.Unix())+
+		"@@ -1,2 +1,2 @@\n one\n-two\n+2\n")
+}
@@ -0,0 +1,356 @@
+package vio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tgulacsi/go-locking"
+)
+
+// acquires an exclusive lock on the index file. The caller is responsible
+// for releasing it.
+func lockIndex(filename string) (flock *locking.FLock, err error) {
+	flock, err = locking.NewFLock(filename)
+	if err != nil {
+		return
+	}
+	if err = flock.Lock(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// the version of the index format written by this version of vio. In
+// format 1, the original one, the index has no header and each line is
+// 'revision#timestamp,{metadata}'. Since format 2, the index is a JSON
+// Lines file: a header with the format, followed by a record per version.
+// Indexes in older formats are read as they are, and kept in their format
+// when updated, until they are migrated with 'vio upgrade'.
+const indexFormat = 2
+
+// the first line of an index since format 2
+type indexHeader struct {
+	Format int `json:"index_format"`
+}
+
+// a version in an index since format 2. Fields added in later formats
+// have to be optional, so that older records remain valid.
+type indexRecord struct {
+	Version string   `json:"version"`
+	Meta    metadata `json:"meta"`
+}
+
+// a line of an index, as it is stored
+type indexEntry struct {
+	line string
+
+	// the version in the line, or nil if it is malformed
+	v   *version
+	err error
+}
+
+// the index of a repository: the versions it has, in the order they were
+// committed. Backends store it as a whole (a file, or an object) and read
+// and update it through this type, so that they all support every format.
+type Index struct {
+	format  int
+	entries []indexEntry
+}
+
+// returns an empty index in the current format
+func newIndex() *Index {
+	return &Index{format: indexFormat}
+}
+
+// the contents of the index of a new repository
+func emptyIndex() []byte {
+	// encoding an empty index can't fail
+	contents, _ := newIndex().encode()
+	return contents
+}
+
+// decodes an index in any of the supported formats. Malformed lines are
+// kept, so that they are written back as they are, and reported by fsck.
+// The header is the first line, but one is looked for in the rest in case
+// the beginning of the index got corrupted.
+func decodeIndex(contents []byte) (idx *Index, err error) {
+	idx = &Index{format: 1}
+	lines := strings.Split(string(contents), "\n")
+	header := -1
+	for i, line := range lines {
+		if format, ok := parseIndexHeader(line); ok {
+			if format < 2 || format > indexFormat {
+				return nil, AnError{fmt.Sprintf(
+					"Index format %d is not supported by this version of vio (%s), "+
+						"which supports up to format %d", format, Version, indexFormat)}
+			}
+			idx.format = format
+			header = i
+			break
+		}
+	}
+	for i, line := range lines {
+		if len(strings.TrimSpace(line)) == 0 || i == header {
+			continue
+		}
+		e := indexEntry{line: line}
+		e.v, e.err = idx.parseLine(line)
+		idx.entries = append(idx.entries, e)
+	}
+	return
+}
+
+func parseIndexHeader(line string) (format int, ok bool) {
+	var h struct {
+		Format *int `json:"index_format"`
+	}
+	if err := json.Unmarshal([]byte(line), &h); err != nil || h.Format == nil {
+		return 0, false
+	}
+	return *h.Format, true
+}
+
+func (idx *Index) parseLine(line string) (*version, error) {
+	if idx.format == 1 {
+		return parseIndexLine(line)
+	}
+	return parseIndexRecord(line)
+}
+
+// parses a record of an index since format 2
+func parseIndexRecord(line string) (v *version, err error) {
+	var r struct {
+		Version string          `json:"version"`
+		Meta    json.RawMessage `json:"meta"`
+	}
+	if err = json.Unmarshal([]byte(line), &r); err != nil || !strings.Contains(r.Version, "#") {
+		return nil, AnError{"Malformed version in index: " + line}
+	}
+	meta := metadata{}
+	if len(r.Meta) > 0 {
+		if meta, err = parseMetadata(r.Meta); err != nil {
+			return nil, AnError{"Malformed metadata in index: " + line}
+		}
+	}
+	if v, err = parseVersion(r.Version, meta); err != nil {
+		return nil, AnError{"Malformed version in index: " + line}
+	}
+	return
+}
+
+// formats a version as a line of the index, in its format
+func (idx *Index) formatLine(v *version) (string, error) {
+	if idx.format == 1 {
+		return fmt.Sprintf("%v", v), nil
+	}
+	meta := v.meta
+	if meta == nil {
+		meta = metadata{}
+	}
+	line, err := json.Marshal(indexRecord{v.id(), meta})
+	return string(line), err
+}
+
+// returns the stored form of the index
+func (idx *Index) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if idx.format > 1 {
+		header, err := json.Marshal(indexHeader{idx.format})
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(append(header, '\n'))
+	}
+	for _, e := range idx.entries {
+		buf.WriteString(e.line + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// the well-formed versions of the index
+func (idx *Index) versions() (versions []version) {
+	versions = []version{}
+	for _, e := range idx.entries {
+		if e.v != nil {
+			versions = append(versions, *e.v)
+		}
+	}
+	return
+}
+
+func (idx *Index) contains(v *version) bool {
+	for _, e := range idx.entries {
+		if e.v != nil && e.v.id() == v.id() {
+			return true
+		}
+	}
+	return false
+}
+
+// adds a version at the end of the index
+func (idx *Index) add(v *version) (err error) {
+	if idx.contains(v) {
+		return AnError{"Version " + fmt.Sprintf("%v", v) + " already in index."}
+	}
+	line, err := idx.formatLine(v)
+	if err != nil {
+		return
+	}
+	idx.entries = append(idx.entries, indexEntry{line: line, v: v})
+	return
+}
+
+// removes versions, which have to be in the index. Remaining lines are
+// kept as they are.
+func (idx *Index) remove(vs []*version) error {
+	removed := map[string]bool{}
+	for _, v := range vs {
+		if !idx.contains(v) {
+			return AnError{"Version " + v.id() + " not in index"}
+		}
+		removed[v.id()] = true
+	}
+	kept := []indexEntry{}
+	for _, e := range idx.entries {
+		if e.v == nil || !removed[e.v.id()] {
+			kept = append(kept, e)
+		}
+	}
+	idx.entries = kept
+	return nil
+}
+
+// migrates the index to the current format. Malformed lines can't be
+// migrated, so they have to be fixed (or removed by fsck) first.
+func (idx *Index) upgrade() (err error) {
+	upgraded := newIndex()
+	for _, e := range idx.entries {
+		if e.v == nil {
+			return AnError{"Can't upgrade an index with malformed lines, " +
+				"run 'vio fsck --repair' or 'vio reindex' first"}
+		}
+		if err = upgraded.add(e.v); err != nil {
+			return
+		}
+	}
+	*idx = *upgraded
+	return
+}
+
+// appends a version to an index file, in the format of the file, and drops
+// it from the list of removed versions if it was removed before. The
+// caller has to hold the index lock.
+func addVersionToIndex(v *version, filename string) (err error) {
+	if err = updateRemovedFile(filepath.Dir(filename), nil, v); err != nil {
+		return
+	}
+
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	idx, err := decodeIndex(contents)
+	if err != nil {
+		return
+	}
+	line, err := idx.formatLine(v)
+	if err != nil {
+		return
+	}
+
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	_, err = f.WriteString(line + "\n")
+
+	return
+}
+
+func readIndex(filename string) (versions []version, err error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	return parseIndex(contents)
+}
+
+// parses an index. Malformed lines are skipped with a warning, so that the
+// rest of the history remains usable until the index is fixed.
+func parseIndex(contents []byte) (versions []version, err error) {
+	idx, err := decodeIndex(contents)
+	if err != nil {
+		return
+	}
+	for _, e := range idx.entries {
+		if e.err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v (run 'vio fsck --repair' or 'vio reindex')\n", e.err)
+		}
+	}
+	return idx.versions(), nil
+}
+
+// parses a line of an index in format 1, of the form
+// 'rev#timestamp,{json}'. Sidecar files also have this form.
+func parseIndexLine(line string) (v *version, err error) {
+	i := strings.Index(line, ",")
+	if i < 0 {
+		return nil, AnError{"Malformed version in index: " + line}
+	}
+	v_str := line[:i]
+	meta_str := line[i+1:]
+
+	meta, err := parseMetadata([]byte(meta_str))
+	if err != nil {
+		return nil, AnError{"Malformed metadata in index: " + line}
+	}
+
+	v, err = parseVersion(v_str, meta)
+	if err != nil || !strings.Contains(v_str, "#") {
+		return nil, AnError{"Malformed version in index: " + line}
+	}
+	return
+}
+
+// returns an index without the given versions, which have to be in it
+func removeFromIndex(contents []byte, vs []*version) ([]byte, error) {
+	idx, err := decodeIndex(contents)
+	if err != nil {
+		return nil, err
+	}
+	if err = idx.remove(vs); err != nil {
+		return nil, err
+	}
+	return idx.encode()
+}
+
+// removes versions from an index file, holding its lock, and lists them as
+// removed. The file is rewritten in place, since the lock is tied to it.
+func removeFromIndexFile(filename string, vs []*version) (err error) {
+	flock, err := lockIndex(filename)
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	updated, err := removeFromIndex(contents, vs)
+	if err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(filename, updated, 0644); err != nil {
+		return
+	}
+	return updateRemovedFile(filepath.Dir(filename), vs, nil)
+}
@@ -0,0 +1,144 @@
+package vio
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the contents of an index in the current format with the given versions
+func indexOf(t *testing.T, versions ...*version) string {
+	idx := newIndex()
+	for _, v := range versions {
+		assert.Nil(t, idx.add(v))
+	}
+	contents, err := idx.encode()
+	assert.Nil(t, err)
+	return string(contents)
+}
+
+const oldIndex = "1234567#1405544146,{\"message\":\"first\"}\n" +
+	"1234567#1405544147,{\"message\":\"second\",\"threads\":\"64\"}\n"
+
+func TestDecodeIndex(t *testing.T) {
+	idx, err := decodeIndex([]byte(oldIndex))
+	assert.Nil(t, err)
+	assert.Equal(t, idx.format, 1)
+	assert.Equal(t, len(idx.versions()), 2)
+	assert.Nil(t, idx.add(NewVersion("1234567#1405544148")))
+	assert.NotNil(t, idx.add(NewVersion("1234567#1405544148")))
+	contents, err := idx.encode()
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), oldIndex+"1234567#1405544148,{}\n")
+
+	v1 := NewVersionWithMeta("1234567#1405544146", metadata{"message": "first"})
+	v2 := NewVersionWithMeta("1234567#1405544147", metadata{"message": "second", "threads": "64"})
+	current := indexOf(t, v1, v2)
+	assert.Equal(t, current, "{\"index_format\":2}\n"+
+		"{\"version\":\"1234567#1405544146\",\"meta\":{\"message\":\"first\"}}\n"+
+		"{\"version\":\"1234567#1405544147\",\"meta\":{\"message\":\"second\",\"threads\":\"64\"}}\n")
+	idx, err = decodeIndex([]byte(current))
+	assert.Nil(t, err)
+	assert.Equal(t, idx.format, indexFormat)
+	assert.Equal(t, idx.versions(), []version{*v1, *v2})
+
+	// a corrupted beginning doesn't hide the header
+	idx, err = decodeIndex([]byte("garbage\n" + current))
+	assert.Nil(t, err)
+	assert.Equal(t, idx.format, indexFormat)
+	assert.Equal(t, len(idx.entries), 3)
+	assert.Equal(t, len(idx.versions()), 2)
+	assert.Nil(t, idx.remove([]*version{v1}))
+	assert.NotNil(t, idx.remove([]*version{v1}))
+	contents, err = idx.encode()
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), strings.Replace(current,
+		"{\"version\":\"1234567#1405544146\",\"meta\":{\"message\":\"first\"}}\n", "garbage\n", 1))
+
+	_, err = decodeIndex([]byte("{\"index_format\":3}\n"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Index format 3 is not supported")
+
+	idx, err = decodeIndex([]byte(oldIndex))
+	assert.Nil(t, err)
+	assert.Nil(t, idx.upgrade())
+	contents, err = idx.encode()
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), current)
+
+	idx, err = decodeIndex([]byte("garbage\n" + oldIndex))
+	assert.Nil(t, err)
+	assert.NotNil(t, idx.upgrade())
+}
+
+func testUpgrade(t *testing.T, backend string) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	assert.Nil(t, Init(".snapshots", backend))
+
+	// an index written by an older version of vio keeps its format when
+	// committing
+	assert.Nil(t, ioutil.WriteFile(".snapshots/index", []byte{}, 0644))
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte("1"), 0644))
+	assert.Nil(t, Commit("first", `{"threads": 8}`))
+	time.Sleep(time.Second)
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte("2"), 0644))
+	assert.Nil(t, Commit("second", "{}"))
+	old, err := ioutil.ReadFile(".snapshots/index")
+	assert.Nil(t, err)
+	assert.False(t, strings.HasPrefix(string(old), "{"))
+	logstr, err := LogWithOptions(LogOptions{Format: "full"})
+	assert.Nil(t, err)
+
+	report, err := Upgrade()
+	assert.Nil(t, err)
+	assert.Equal(t, report, "index upgraded from format 1 to 2, the previous one is kept in index.bak\n")
+	backup, err := ioutil.ReadFile(".snapshots/index.bak")
+	assert.Nil(t, err)
+	assert.Equal(t, string(backup), string(old))
+	upgraded, err := ioutil.ReadFile(".snapshots/index")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(upgraded), "{\"index_format\":2}\n"))
+	assert.Contains(t, string(upgraded), "\"threads\":8")
+
+	upgradedLog, err := LogWithOptions(LogOptions{Format: "full"})
+	assert.Nil(t, err)
+	assert.Equal(t, upgradedLog, logstr)
+	report, err = Fsck(false)
+	assert.Nil(t, err)
+	assert.Equal(t, report, "")
+
+	report, err = Upgrade()
+	assert.Nil(t, err)
+	assert.Equal(t, report, "index is up to date (format 2)\n")
+
+	time.Sleep(time.Second)
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte("3"), 0644))
+	assert.Nil(t, Commit("third", "{}"))
+	b, err := load()
+	assert.Nil(t, err)
+	vs, err := b.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(vs), 3)
+	assert.Nil(t, b.Remove([]*version{&vs[0]}))
+	current, err := ioutil.ReadFile(".snapshots/index")
+	assert.Nil(t, err)
+	assert.Equal(t, string(current), indexOf(t, &vs[1], &vs[2]))
+}
+
+func TestPosixUpgrade(t *testing.T) {
+	testUpgrade(t, "posix")
+}
+
+func TestCasUpgrade(t *testing.T) {
+	testUpgrade(t, "cas")
+}
+
+func TestGitUpgrade(t *testing.T) {
+	testUpgrade(t, "git")
+}
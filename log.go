@@ -0,0 +1,347 @@
+package vio
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// options for filtering and formatting the output of Log
+type LogOptions struct {
+	// one of 'oneline' (the default), 'full', 'json' or 'csv'
+	Format string
+
+	// only show versions committed in this time range. Accepts unix
+	// timestamps, dates ('2006-01-02'), date-times ('2006-01-02 15:04' or
+	// RFC3339) and relative times ('36h', '7d', '2w' ago).
+	Since string
+	Until string
+
+	// only show versions of this git commit (a hash, prefix or ref)
+	Revision string
+
+	// only show versions whose metadata satisfies all these predicates,
+	// e.g. 'threads=64', 'runtime>10' or 'host.name!=node1'
+	Where []string
+}
+
+func Log() (logstr string, err error) {
+	return LogWithOptions(LogOptions{})
+}
+
+func LogWithOptions(o LogOptions) (logstr string, err error) {
+	b, err := load()
+	if err != nil {
+		return
+	}
+	f, err := newVersionFilter(o.Revision, o.Since, o.Until, o.Where)
+	if err != nil {
+		return
+	}
+	versions, err := annotatedVersions(b)
+	if err != nil {
+		return
+	}
+	tags, err := b.GetTags()
+	if err != nil {
+		return
+	}
+	return formatLog(f.apply(versions), tagsByVersion(tags), o.Format)
+}
+
+// formats versions, showing the tags of each one (indexed by version ID)
+func formatLog(versions []version, tags map[string][]string, format string) (logstr string, err error) {
+	switch format {
+	case "", "oneline":
+		for _, v := range versions {
+			decoration := ""
+			if names := tags[v.id()]; len(names) > 0 {
+				decoration = " (" + strings.Join(names, ", ") + ")"
+			}
+			logstr += fmt.Sprintf("%s%s %s\n", v.id(), decoration, v.meta.str("message"))
+		}
+	case "full":
+		for i, v := range versions {
+			if i > 0 {
+				logstr += "\n"
+			}
+			var headers []string
+			if names := tags[v.id()]; len(names) > 0 {
+				headers = append(headers, "Tags: "+strings.Join(names, ", "))
+			}
+			logstr += formatFull(v, headers...)
+		}
+	case "json":
+		return formatJSON(versions, tags)
+	case "csv":
+		return formatCSV(versions, tags)
+	default:
+		return "", AnError{"Unknown log format '" + format + "'"}
+	}
+	return
+}
+
+// sorted metadata keys of the given versions, excluding the message. Keys
+// of nested objects are flattened, e.g. 'params.threads'.
+func metaKeys(versions ...version) (keys []string) {
+	seen := map[string]bool{"message": true}
+	for _, v := range versions {
+		for k := range v.meta.flatten() {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+// formats a version like 'git log' does, adding the given header lines
+// after the first one
+func formatFull(v version, headers ...string) string {
+	s := fmt.Sprintf("version %s#%d\n", v.revision, v.timestamp.Unix())
+	for _, h := range headers {
+		s += h + "\n"
+	}
+	s += fmt.Sprintf("Date: %s\n\n    %s\n",
+		v.timestamp.Format("Mon Jan 2 15:04:05 2006 -0700"),
+		strings.TrimSpace(v.meta.str("message")))
+
+	flat := v.meta.flatten()
+	keys := metaKeys(v)
+	if len(keys) > 0 {
+		s += "\n"
+	}
+	for _, k := range keys {
+		s += fmt.Sprintf("    %s: %s\n", k, flat[k])
+	}
+	return s
+}
+
+// an entry of the JSON output of Log
+type logEntry struct {
+	Version   string   `json:"version"`
+	Revision  string   `json:"revision"`
+	Timestamp int64    `json:"timestamp"`
+	Tags      []string `json:"tags,omitempty"`
+	Meta      metadata `json:"meta"`
+}
+
+func formatJSON(versions []version, tags map[string][]string) (string, error) {
+	entries := []logEntry{}
+	for _, v := range versions {
+		entries = append(entries, logEntry{
+			Version:   v.id(),
+			Revision:  v.revision,
+			Timestamp: v.timestamp.Unix(),
+			Tags:      tags[v.id()],
+			Meta:      v.meta})
+	}
+	out, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out) + "\n", nil
+}
+
+// one row per version, with a column for each metadata key of any of them
+func formatCSV(versions []version, tags map[string][]string) (string, error) {
+	keys := metaKeys(versions...)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(append([]string{"version", "revision", "timestamp", "tags", "message"}, keys...))
+	for _, v := range versions {
+		flat := v.meta.flatten()
+		row := []string{
+			v.id(),
+			v.revision,
+			strconv.FormatInt(v.timestamp.Unix(), 10),
+			strings.Join(tags[v.id()], " "),
+			v.meta.str("message")}
+		for _, k := range keys {
+			row = append(row, flat[k])
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+// selects versions by git revision, time range and metadata
+type versionFilter struct {
+	// full hash of the git commit, or the given revision if it couldn't be
+	// resolved
+	revision   string
+	since      time.Time
+	until      time.Time
+	predicates []predicate
+}
+
+func newVersionFilter(revision string, since string, until string,
+	where []string) (f *versionFilter, err error) {
+
+	f = &versionFilter{}
+
+	if revision != "" {
+		f.revision = revision
+		// the revision might not be in the repo (e.g. an old clone), in
+		// which case it's matched as given
+		if commit, ok := resolveGitRevision(revision); ok {
+			f.revision = commit
+		}
+	}
+
+	now := time.Now()
+	if since != "" {
+		if f.since, err = parseTime(since, now); err != nil {
+			return
+		}
+	}
+	if until != "" {
+		if f.until, err = parseTime(until, now); err != nil {
+			return
+		}
+	}
+
+	for _, w := range where {
+		p, err := parsePredicate(w)
+		if err != nil {
+			return nil, err
+		}
+		f.predicates = append(f.predicates, p)
+	}
+	return
+}
+
+func (f *versionFilter) matches(v version) bool {
+	// the index stores abbreviated hashes
+	if f.revision != "" && !strings.HasPrefix(f.revision, v.revision) &&
+		!strings.HasPrefix(v.revision, f.revision) {
+		return false
+	}
+	if !f.since.IsZero() && v.timestamp.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && v.timestamp.After(f.until) {
+		return false
+	}
+	for _, p := range f.predicates {
+		if !p.matches(v.meta) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *versionFilter) apply(versions []version) (filtered []version) {
+	for _, v := range versions {
+		if f.matches(v) {
+			filtered = append(filtered, v)
+		}
+	}
+	return
+}
+
+var relativeTime = regexp.MustCompile(`^(\d+)\s*([smhdw])$`)
+
+// parses an absolute or relative (to now) point in time
+func parseTime(s string, now time.Time) (t time.Time, err error) {
+	s = strings.TrimSpace(s)
+
+	if m := relativeTime.FindStringSubmatch(s); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		unit := map[string]time.Duration{
+			"s": time.Second,
+			"m": time.Minute,
+			"h": time.Hour,
+			"d": 24 * time.Hour,
+			"w": 7 * 24 * time.Hour}[m[2]]
+		return now.Add(-time.Duration(n) * unit), nil
+	}
+
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+
+	if t, err = time.Parse(time.RFC3339, s); err == nil {
+		return
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err = time.ParseInLocation(layout, s, time.Local); err == nil {
+			return
+		}
+	}
+	return t, AnError{"Unable to parse time '" + s + "'"}
+}
+
+// a condition on a metadata key
+type predicate struct {
+	key   string
+	op    string
+	value string
+}
+
+// operators of predicates, longest first so that '>=' isn't taken for '>'
+var predicateOps = []string{"!=", ">=", "<=", "=", ">", "<"}
+
+func parsePredicate(s string) (p predicate, err error) {
+	i := strings.IndexAny(s, "=!<>")
+	if i <= 0 {
+		return p, AnError{"Malformed predicate '" + s + "'"}
+	}
+	for _, op := range predicateOps {
+		if strings.HasPrefix(s[i:], op) {
+			p = predicate{strings.TrimSpace(s[:i]), op, strings.TrimSpace(s[i+len(op):])}
+			break
+		}
+	}
+	if p.op == "" {
+		return p, AnError{"Malformed predicate '" + s + "'"}
+	}
+	if p.op != "=" && p.op != "!=" {
+		if _, err := strconv.ParseFloat(p.value, 64); err != nil {
+			return p, AnError{"Expecting a number in predicate '" + s + "'"}
+		}
+	}
+	return
+}
+
+// whether the metadata satisfies the predicate. Values are compared as
+// numbers whenever both sides are numeric, so 'threads=64' matches '64.0'.
+// Missing keys only satisfy '!='. Keys of nested objects are given as a
+// path, e.g. 'params.lr>0.01'.
+func (p predicate) matches(meta metadata) bool {
+	raw, ok := meta.get(p.key)
+	if !ok {
+		return p.op == "!="
+	}
+	value := formatValue(raw)
+
+	a, errA := strconv.ParseFloat(value, 64)
+	b, errB := strconv.ParseFloat(p.value, 64)
+	numeric := errA == nil && errB == nil
+
+	switch p.op {
+	case "=":
+		return value == p.value || numeric && a == b
+	case "!=":
+		return !(value == p.value || numeric && a == b)
+	case ">":
+		return numeric && a > b
+	case "<":
+		return numeric && a < b
+	case ">=":
+		return numeric && a >= b
+	case "<=":
+		return numeric && a <= b
+	}
+	return false
+}
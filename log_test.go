@@ -0,0 +1,131 @@
+package vio
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePredicate(t *testing.T) {
+	meta := metadata{"threads": "64", "runtime": "12.5", "host.name": "node1"}
+
+	for s, expected := range map[string]bool{
+		"threads=64":        true,
+		"threads=64.0":      true,
+		"threads!=64":       false,
+		"threads>32":        true,
+		"threads>=64":       true,
+		"threads<64":        false,
+		"runtime<=12.5":     true,
+		"host.name=node1":   true,
+		"host.name!=node2":  true,
+		"missing=1":         false,
+		"missing!=1":        true,
+		" threads = 64 ":    true,
+		"host.name=node1 ":  true,
+		"runtime>12.4":      true,
+		"threads<=63.99999": false,
+	} {
+		p, err := parsePredicate(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, p.matches(meta), expected, s)
+	}
+
+	for _, s := range []string{"threads", "=64", "threads>many", "threads!64"} {
+		_, err := parsePredicate(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2015, 11, 23, 12, 0, 0, 0, time.Local)
+
+	for s, expected := range map[string]time.Time{
+		"7d":               now.Add(-7 * 24 * time.Hour),
+		"2w":               now.Add(-14 * 24 * time.Hour),
+		"36h":              now.Add(-36 * time.Hour),
+		"1448281434":       time.Unix(1448281434, 0),
+		"2015-11-20":       time.Date(2015, 11, 20, 0, 0, 0, 0, time.Local),
+		"2015-11-20 10:30": time.Date(2015, 11, 20, 10, 30, 0, 0, time.Local),
+	} {
+		parsed, err := parseTime(s, now)
+		assert.Nil(t, err, s)
+		assert.True(t, parsed.Equal(expected), s)
+	}
+
+	_, err := parseTime("last tuesday", now)
+	assert.NotNil(t, err)
+}
+
+func TestLogWithOptions(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	err = Init(".snapshots", "posix")
+	assert.Nil(t, err)
+
+	id, err := GetCurrentCommitId(path)
+	assert.Nil(t, err)
+
+	now := time.Now().Unix()
+	versions := []*version{
+		NewVersionWithMeta("0123abc#"+itoa(now-10*86400), metadata{"message": "old", "threads": "64"}),
+		NewVersionWithMeta(id+"#"+itoa(now-3600), metadata{"message": "first", "threads": "32"}),
+		NewVersionWithMeta(id+"#"+itoa(now), metadata{"message": "second, again", "threads": "64"}),
+	}
+	for _, v := range versions {
+		assert.Nil(t, addVersionToIndex(v, ".snapshots/index"))
+	}
+
+	logstr, err := Log()
+	assert.Nil(t, err)
+	assert.Equal(t, len(strings.Split(strings.TrimSpace(logstr), "\n")), 3)
+	assert.True(t, strings.HasPrefix(logstr, "0123abc#"))
+
+	logstr, err = LogWithOptions(LogOptions{Since: "7d", Where: []string{"threads=64"}})
+	assert.Nil(t, err)
+	assert.Equal(t, logstr, id+"#"+itoa(now)+" second, again\n")
+
+	logstr, err = LogWithOptions(LogOptions{Revision: "HEAD"})
+	assert.Nil(t, err)
+	assert.Equal(t, len(strings.Split(strings.TrimSpace(logstr), "\n")), 2)
+
+	logstr, err = LogWithOptions(LogOptions{Until: "2h"})
+	assert.Nil(t, err)
+	assert.Equal(t, logstr, "0123abc#"+itoa(now-10*86400)+" old\n")
+
+	logstr, err = LogWithOptions(LogOptions{Format: "json", Where: []string{"threads<64"}})
+	assert.Nil(t, err)
+	var entries []logEntry
+	assert.Nil(t, json.Unmarshal([]byte(logstr), &entries))
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].Revision, id)
+	assert.Equal(t, entries[0].Meta["message"], "first")
+
+	logstr, err = LogWithOptions(LogOptions{Format: "csv", Revision: id})
+	assert.Nil(t, err)
+	assert.Equal(t, logstr,
+		"version,revision,timestamp,tags,message,threads\n"+
+			id+"#"+itoa(now-3600)+","+id+","+itoa(now-3600)+",,first,32\n"+
+			id+"#"+itoa(now)+","+id+","+itoa(now)+",,\"second, again\",64\n")
+
+	logstr, err = LogWithOptions(LogOptions{Format: "full", Revision: "0123abc"})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(logstr, "version 0123abc#"+itoa(now-10*86400)+"\nDate: "))
+	assert.True(t, strings.HasSuffix(logstr, "\n    old\n\n    threads: 64\n"))
+
+	_, err = LogWithOptions(LogOptions{Format: "xml"})
+	assert.NotNil(t, err)
+	_, err = LogWithOptions(LogOptions{Where: []string{"threads"}})
+	assert.NotNil(t, err)
+}
+
+func itoa(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
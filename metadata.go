@@ -0,0 +1,147 @@
+package vio
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// the metadata of a version: a JSON object whose values can be strings,
+// numbers (kept as json.Number, so that they are stored as given), booleans,
+// lists or nested objects. Indexes written before metadata was typed only
+// have strings, which are valid metadata as they are.
+type metadata map[string]interface{}
+
+// parses a JSON object, keeping numbers as they are written
+func parseMetadata(data []byte) (m metadata, err error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err = d.Decode(&m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, AnError{"Expecting a JSON object"}
+	}
+	if d.More() {
+		return nil, AnError{"Unexpected data after JSON object"}
+	}
+	return
+}
+
+// returns the value of a key. Keys of nested objects can be given as a path
+// separated by dots (e.g. 'params.threads'), unless there is a key with the
+// whole path, which takes precedence.
+func (m metadata) get(key string) (value interface{}, ok bool) {
+	if value, ok = m[key]; ok {
+		return
+	}
+	parts := strings.Split(key, ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		nested, ok := m[strings.Join(parts[:i], ".")].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if value, ok = metadata(nested).get(strings.Join(parts[i:], ".")); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// removes a key, given as get takes it. The nested objects on its path are
+// copied before being changed, since copies of the metadata share them.
+// Returns whether the key was there.
+func (m metadata) remove(key string) bool {
+	if _, ok := m[key]; ok {
+		delete(m, key)
+		return true
+	}
+	parts := strings.Split(key, ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		prefix := strings.Join(parts[:i], ".")
+		nested, ok := m[prefix].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		c := metadata(nested).copy()
+		if c.remove(strings.Join(parts[i:], ".")) {
+			m[prefix] = map[string]interface{}(c)
+			return true
+		}
+	}
+	return false
+}
+
+// parses a value given in the command line: as JSON if it is valid JSON
+// (e.g. '64', 'true' or '[1, 2]'), and as a string otherwise
+func parseValue(s string) interface{} {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	var value interface{}
+	if err := d.Decode(&value); err != nil || d.More() {
+		return s
+	}
+	return value
+}
+
+// returns the value of a key formatted as a string, or an empty string if
+// there's no such key
+func (m metadata) str(key string) string {
+	value, _ := m.get(key)
+	return formatValue(value)
+}
+
+// formats a metadata value: strings as they are, lists and objects as JSON
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	s, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(s)
+}
+
+// returns the metadata with nested objects flattened into keys separated by
+// dots and values formatted as strings
+func (m metadata) flatten() map[string]string {
+	flat := map[string]string{}
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, value := range m {
+			if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+				walk(prefix+k+".", nested)
+				continue
+			}
+			flat[prefix+k] = formatValue(value)
+		}
+	}
+	walk("", m)
+	return flat
+}
+
+// returns a copy of the metadata that can be modified without affecting the
+// original one. Nested values are shared.
+func (m metadata) copy() metadata {
+	c := metadata{}
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// whether two metadata have the same keys and values
+func (m metadata) equal(other metadata) bool {
+	s1, err1 := json.Marshal(m)
+	s2, err2 := json.Marshal(other)
+	return err1 == nil && err2 == nil && bytes.Equal(s1, s2)
+}
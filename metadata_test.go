@@ -0,0 +1,86 @@
+package vio
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetadata(t *testing.T) {
+	meta, err := parseMetadata([]byte(`{"threads": 64, "lr": 0.10, "ok": true,
+		"flags": [1, 2], "params": {"lr": 0.1, "opt": {"name": "adam"}}, "params.lr": "given"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, meta["threads"], json.Number("64"))
+	assert.Equal(t, meta.str("lr"), "0.10")
+	assert.Equal(t, meta.str("ok"), "true")
+	assert.Equal(t, meta.str("flags"), "[1,2]")
+	assert.Equal(t, meta.str("params.opt.name"), "adam")
+	assert.Equal(t, meta.str("params.lr"), "given")
+	_, ok := meta.get("params.missing")
+	assert.False(t, ok)
+
+	flat := meta.flatten()
+	assert.Equal(t, flat["params.opt.name"], "adam")
+	assert.Equal(t, flat["flags"], "[1,2]")
+	assert.Equal(t, flat["threads"], "64")
+
+	for _, malformed := range []string{"", "null", "[1]", `"a"`, `{"a": 1} {}`} {
+		_, err = parseMetadata([]byte(malformed))
+		assert.NotNil(t, err, malformed)
+	}
+}
+
+func TestTypedMetadata(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	assert.Nil(t, Init(".snapshots", "posix"))
+
+	// an index line written before metadata was typed
+	id, err := GetCurrentCommitId(path)
+	assert.Nil(t, err)
+	old := id + "#1448281434," + `{"message":"old","threads":"32","params.lr":"0.01"}` + "\n"
+	assert.Nil(t, ioutil.WriteFile(".snapshots/index", []byte(old), 0644))
+
+	assert.Nil(t, Commit("typed",
+		`{"threads": 64, "ok": true, "params": {"lr": 0.1, "layers": [64, 32]}}`))
+
+	b, err := load()
+	assert.Nil(t, err)
+	vs, err := b.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(vs), 2)
+	assert.Equal(t, vs[0].meta["threads"], "32")
+	assert.Equal(t, vs[1].meta["threads"], json.Number("64"))
+	assert.Equal(t, vs[1].meta["ok"], true)
+	assert.Equal(t, vs[1].meta.str("params.layers"), "[64,32]")
+
+	index, err := ioutil.ReadFile(".snapshots/index")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(index), old))
+	assert.Contains(t, string(index), `"params":{"layers":[64,32],"lr":0.1}`)
+
+	logstr, err := LogWithOptions(LogOptions{Where: []string{"params.lr>0.05"}})
+	assert.Nil(t, err)
+	assert.Equal(t, logstr, vs[1].id()+" typed\n")
+
+	logstr, err = LogWithOptions(LogOptions{Where: []string{"threads>=32", "ok=true"}})
+	assert.Nil(t, err)
+	assert.Equal(t, logstr, vs[1].id()+" typed\n")
+
+	logstr, err = LogWithOptions(LogOptions{Format: "json", Where: []string{"threads=64"}})
+	assert.Nil(t, err)
+	assert.Contains(t, logstr, `"threads": 64`)
+	assert.Contains(t, logstr, `"ok": true`)
+
+	logstr, err = LogWithOptions(LogOptions{Format: "full", Where: []string{"threads=64"}})
+	assert.Nil(t, err)
+	assert.Contains(t, logstr, "    params.layers: [64,32]\n    params.lr: 0.1\n")
+
+	assert.NotNil(t, Commit("list", `[1, 2]`))
+}
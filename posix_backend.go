@@ -1,21 +1,25 @@
 package vio
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"gopkg.in/ini.v1"
-
-	"github.com/tgulacsi/go-locking"
 )
 
 type PosixBackend struct {
 	snapshotsPath string
 	repoPath      string
+
+	// whether files that didn't change since the previous snapshot of the
+	// same revision are hardlinked instead of copied
+	incremental bool
 }
 
 func NewPosixBackend(o *ini.File) (b Backend, err error) {
@@ -25,9 +29,16 @@ func NewPosixBackend(o *ini.File) (b Backend, err error) {
 	if !o.Section("").HasKey("repo_path") {
 		return nil, AnError{"Expecting key 'repo_path' in configuration."}
 	}
+	incremental := false
+	if o.Section("").HasKey("incremental") {
+		if incremental, err = o.Section("").Key("incremental").Bool(); err != nil {
+			return nil, AnError{"Expecting a boolean for 'incremental' in configuration."}
+		}
+	}
 	return &PosixBackend{
 		snapshotsPath: o.Section("").Key("snapshots_path").String(),
-		repoPath:      o.Section("").Key("repo_path").String()}, nil
+		repoPath:      o.Section("").Key("repo_path").String(),
+		incremental:   incremental}, nil
 }
 
 func (b PosixBackend) Init() (err error) {
@@ -39,28 +50,15 @@ func (b PosixBackend) Init() (err error) {
 		return AnError{"Repository already initialized"}
 	}
 
-	if err = ioutil.WriteFile(b.snapshotsPath+"/index", []byte(""), 0644); err != nil {
+	if err = ioutil.WriteFile(b.snapshotsPath+"/index", emptyIndex(), 0644); err != nil {
 		return
 	}
 
 	return
 }
 
-func (b PosixBackend) isRepoOK() (err error) {
-	if !b.IsInitialized() {
-		return AnError{"Uninitialized repository."}
-	}
-
-	hasUncommitted, err := HasUncommittedChanges(b.repoPath)
-
-	if err != nil {
-		return
-	}
-	if hasUncommitted {
-		return AnError{"Uncommitted changes in repo."}
-	}
-
-	return
+func (b PosixBackend) isRepoOK() error {
+	return checkRepo(b, b.repoPath)
 }
 
 func (b PosixBackend) Open() error {
@@ -73,7 +71,7 @@ func (b PosixBackend) IsInitialized() bool {
 }
 
 func (b PosixBackend) GetStatus() (Status, error) {
-	return Committed, nil
+	return stagingStatus(b.repoPath)
 }
 
 func (b PosixBackend) Checkout(v *version) (err error) {
@@ -81,14 +79,10 @@ func (b PosixBackend) Checkout(v *version) (err error) {
 		return
 	}
 
-	// acquire a lock on the index file
-	flock, err := locking.NewFLock(b.snapshotsPath + "/index")
+	flock, err := lockIndex(b.snapshotsPath + "/index")
 	if err != nil {
 		return
 	}
-	if err = flock.Lock(); err != nil {
-		return
-	}
 	defer flock.Unlock()
 
 	idx, err := b.GetVersions()
@@ -104,8 +98,8 @@ func (b PosixBackend) Checkout(v *version) (err error) {
 	return checkoutSnapshot(b.repoPath, b.snapshotsPath, v)
 }
 
-func (b PosixBackend) Commit(meta map[string]string) (v *version, err error) {
-	if err = b.isRepoOK(); err != nil {
+func (b PosixBackend) Commit(meta metadata) (v *version, err error) {
+	if err = checkCommit(b, b.repoPath, meta); err != nil {
 		return
 	}
 	versionedFiles, err := GetVersionedFiles(b.repoPath)
@@ -120,14 +114,10 @@ func (b PosixBackend) Commit(meta map[string]string) (v *version, err error) {
 
 	v = NewVersionWithMeta(id, meta)
 
-	// acquire a lock on the index file
-	flock, err := locking.NewFLock(b.snapshotsPath + "/index")
+	flock, err := lockIndex(b.snapshotsPath + "/index")
 	if err != nil {
 		return
 	}
-	if err = flock.Lock(); err != nil {
-		return
-	}
 	defer flock.Unlock()
 
 	idx, err := b.GetVersions()
@@ -138,7 +128,26 @@ func (b PosixBackend) Commit(meta map[string]string) (v *version, err error) {
 		return nil, AnError{"Version " + fmt.Sprintf("%v", v) + " already in index."}
 	}
 
-	if err = createSnapshot(b.repoPath, b.snapshotsPath, v, versionedFiles); err != nil {
+	linkDest := ""
+	if b.incremental {
+		if prev := latestOfRevision(idx, v.revision); prev != nil {
+			linkDest = b.snapshotPath(prev)
+		}
+	}
+
+	staged, err := stagedFiles(b.repoPath, b.snapshotsPath, versionedFiles)
+	if err != nil {
+		return
+	}
+
+	if err = createSnapshot(b.repoPath, b.snapshotsPath, v, versionedFiles, staged, linkDest); err != nil {
+		return
+	}
+
+	if err = b.writeManifest(v); err != nil {
+		return
+	}
+	if err = writeSidecar(b.snapshotsPath, v); err != nil {
 		return
 	}
 
@@ -146,6 +155,7 @@ func (b PosixBackend) Commit(meta map[string]string) (v *version, err error) {
 		return
 	}
 
+	err = clearStaged(b.repoPath)
 	return
 }
 
@@ -176,8 +186,12 @@ func checkoutSnapshot(repoPath string, snapsPath string, v *version) (err error)
 	return
 }
 
-func createSnapshot(repoPath string,
-	snapsPath string, v *version, versionedFiles []string) (err error) {
+// copies the unversioned files of the repo into the snapshot folder of a
+// version, or only the given files if there are any. If linkDest is given,
+// files that are identical to the ones in that folder are hardlinked to them
+// instead of being copied.
+func createSnapshot(repoPath string, snapsPath string, v *version,
+	versionedFiles []string, files []string, linkDest string) (err error) {
 
 	if err = os.MkdirAll(snapsPath+"/"+v.revision, 0755); err != nil {
 		return
@@ -191,14 +205,28 @@ func createSnapshot(repoPath string,
 
 	var args []string
 	args = append(args, "-a")
-	for _, vfile := range versionedFiles {
-		args = append(args, "--exclude="+vfile)
-	}
+	args = append(args, unversionedFilters(repoPath, snapsPath, versionedFiles)...)
 
-	args = append(args, "--exclude=.git/")
+	if len(files) > 0 {
+		list, err := ioutil.TempFile("", "vio-files-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(list.Name())
+		_, err = list.WriteString(strings.Join(files, "\n") + "\n")
+		list.Close()
+		if err != nil {
+			return err
+		}
+		args = append(args, "--files-from="+list.Name())
+	}
 
-	if _, err := os.Stat(repoPath + "/.vioignore"); err == nil {
-		args = append(args, "--filter=:-_/.vioignore")
+	if linkDest != "" {
+		// rsync interprets relative paths as relative to the destination
+		if linkDest, err = filepath.Abs(linkDest); err != nil {
+			return
+		}
+		args = append(args, "--link-dest="+linkDest)
 	}
 
 	// source
@@ -212,51 +240,405 @@ func createSnapshot(repoPath string,
 	return
 }
 
-func addVersionToIndex(v *version, filename string) (err error) {
-	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0600)
+// rsync filters that select the unversioned files of a repo
+func unversionedFilters(repoPath string, snapsPath string, versionedFiles []string) (args []string) {
+	for _, vfile := range versionedFiles {
+		args = append(args, "--exclude="+vfile)
+	}
+
+	// a file in worktrees and submodules
+	args = append(args, "--exclude=.git")
+
+	// don't snapshot the snapshots folder if it lives inside the repo
+	if rel, ok := relativeToRepo(repoPath, snapsPath); ok {
+		args = append(args, "--exclude=/"+rel+"/")
+	}
+
+	if _, err := os.Stat(repoPath + "/.vioignore"); err == nil {
+		args = append(args, "--filter=:-_/.vioignore")
+	}
+
+	return
+}
+
+// lists the files that createSnapshot would copy, relative to the repo. It
+// is used by backends that don't store snapshots as plain folders.
+func listUnversionedFiles(repoPath string,
+	snapsPath string, versionedFiles []string) (files []string, err error) {
+
+	// an empty destination makes rsync consider every file as new
+	dest, err := ioutil.TempDir("", "vio")
 	if err != nil {
 		return
 	}
+	defer os.RemoveAll(dest)
 
-	defer f.Close()
+	var args []string
+	args = append(args, "-a", "--dry-run", "--out-format=%n")
+	args = append(args, unversionedFilters(repoPath, snapsPath, versionedFiles)...)
+	args = append(args, repoPath+"/", dest)
 
-	_, err = f.WriteString(fmt.Sprintf("%v\n", v))
+	out, err := exec.Command("rsync", args...).Output()
+	if err != nil {
+		return
+	}
+
+	files = []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" || strings.HasSuffix(line, "/") {
+			continue
+		}
+		files = append(files, line)
+	}
+	return
+}
 
+// returns the most recent version of a revision, or nil if there's none
+func latestOfRevision(versions []version, revision string) (latest *version) {
+	for i, v := range versions {
+		if v.revision != revision {
+			continue
+		}
+		if latest == nil || v.timestamp.After(latest.timestamp) {
+			latest = &versions[i]
+		}
+	}
 	return
 }
 
+// returns the path of p relative to the repo, if p is inside of it
+func relativeToRepo(repoPath string, p string) (string, bool) {
+	absRepo, err := filepath.Abs(repoPath)
+	if err != nil {
+		return "", false
+	}
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(absRepo, absPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
 func (b PosixBackend) GetVersions() (versions []version, err error) {
-	contents, err := ioutil.ReadFile(b.snapshotsPath + "/index")
+	return readIndex(b.snapshotsPath + "/index")
+}
+
+func (b PosixBackend) GetTags() (map[string]string, error) {
+	return readTagsFile(b.snapshotsPath)
+}
+
+func (b PosixBackend) SetTag(name string, v *version) error {
+	return updateTagsFile(b.snapshotsPath, func(tags map[string]string) error {
+		return addTag(tags, name, v)
+	})
+}
+
+func (b PosixBackend) DeleteTag(name string) error {
+	return updateTagsFile(b.snapshotsPath, func(tags map[string]string) error {
+		return removeTag(tags, name)
+	})
+}
+
+func (b PosixBackend) GetAnnotations() ([]annotation, error) {
+	return readAnnotationsFile(b.snapshotsPath)
+}
+
+func (b PosixBackend) Annotate(a annotation) error {
+	return appendAnnotationFile(b.snapshotsPath, a)
+}
+
+func (b PosixBackend) Remove(vs []*version) error {
+	return removeFromIndexFile(b.snapshotsPath+"/index", vs)
+}
+
+// removes the snapshot folders of versions removed from the index. Files
+// hardlinked from other snapshots don't count as freed space.
+func (b PosixBackend) GC(dryRun bool) (removed []string, freed int64, err error) {
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	idx, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+	kept := map[string]bool{}
+	for i := range idx {
+		kept[b.snapshotPath(&idx[i])] = true
+	}
+	removedVersions, err := readRemovedFile(b.snapshotsPath)
 	if err != nil {
 		return
 	}
 
-	versions = []version{}
-	lines := strings.Split(string(contents), "\n")
-	for _, line := range lines {
-		if len(strings.TrimSpace(line)) == 0 {
+	revisions, err := ioutil.ReadDir(b.snapshotsPath)
+	if err != nil {
+		return
+	}
+	for _, rev := range revisions {
+		if !rev.IsDir() || rev.Name() == "manifests" || rev.Name() == "metadata" {
 			continue
 		}
-		i := strings.Index(line, ",")
-		if i < 0 {
-			return nil, AnError{"Malformed version in index: " + line}
+		revPath := b.snapshotsPath + "/" + rev.Name()
+		snapshots, err := ioutil.ReadDir(revPath)
+		if err != nil {
+			return nil, 0, err
+		}
+		left := len(snapshots)
+		for _, snap := range snapshots {
+			path := revPath + "/" + snap.Name()
+			if _, err := strconv.ParseInt(snap.Name(), 10, 64); err != nil || !snap.IsDir() ||
+				kept[path] || !collectable(path, removedVersions) {
+				continue
+			}
+			size, err := exclusiveSize(path)
+			if err != nil {
+				return nil, 0, err
+			}
+			removed = append(removed, path)
+			freed += size
+			left--
+			if dryRun {
+				continue
+			}
+			if err = os.RemoveAll(path); err != nil {
+				return nil, 0, err
+			}
+			for _, dir := range []string{"manifests", "metadata"} {
+				sidecar := fmt.Sprintf("%s/%s/%s/%s", b.snapshotsPath, dir, rev.Name(), snap.Name())
+				if err = os.Remove(sidecar); err != nil && !os.IsNotExist(err) {
+					return nil, 0, err
+				}
+			}
+		}
+		if left == 0 && !dryRun {
+			if err = os.Remove(revPath); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if dryRun {
+		return
+	}
+	for _, dir := range []string{"manifests", "metadata"} {
+		if _, err = os.Stat(b.snapshotsPath + "/" + dir); err == nil {
+			err = removeEmptyDirs(b.snapshotsPath + "/" + dir)
+		} else if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return
 		}
-		v_str := line[:i]
-		meta_str := line[i+1:]
+	}
+	return
+}
 
-		var meta map[string]string
+func (b PosixBackend) Import(v *version, dir string) (err error) {
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
 
-		err = json.Unmarshal([]byte(meta_str), &meta)
+	idx, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+	if ContainsVersion(idx, v) {
+		return AnError{"Version " + v.id() + " already in index."}
+	}
+
+	if err = copyDir(dir, b.snapshotPath(v)); err != nil {
+		return
+	}
+	if err = b.writeManifest(v); err != nil {
+		return
+	}
+	if err = writeSidecar(b.snapshotsPath, v); err != nil {
+		return
+	}
+	return addVersionToIndex(v, b.snapshotsPath+"/index")
+}
+
+func (b PosixBackend) Diff(v1 *version, v2 *version, obj string) (string, error) {
+	if err := checkInIndex(b, v1, v2); err != nil {
+		return "", err
+	}
+	return diffVersions(b, v1, v2, obj)
+}
+
+func (b PosixBackend) snapshotPath(v *version) string {
+	return fmt.Sprintf("%s/%s/%d", b.snapshotsPath, v.revision, v.timestamp.Unix())
+}
+
+// snapshots are plain folders, so their checksums are kept apart, in
+// '<snapshots>/manifests/<revision>/<timestamp>', with the same format
+// used by CasBackend
+func (b PosixBackend) manifestPath(v *version) string {
+	return fmt.Sprintf("%s/manifests/%s/%d", b.snapshotsPath, v.revision, v.timestamp.Unix())
+}
+
+// records the checksums of the files in the snapshot of a version
+func (b PosixBackend) writeManifest(v *version) (err error) {
+	manifest, err := manifestOf(b.snapshotPath(v))
+	if err != nil {
+		return
+	}
+	return writeManifestFile(b.manifestPath(v), manifest)
+}
+
+func (b PosixBackend) ListFiles(v *version) (files []FileInfo, err error) {
+	root := b.snapshotPath(v)
+	if _, err = os.Stat(root); err != nil {
+		return
+	}
+	files = []FileInfo{}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return
+			return err
 		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, FileInfo{
+			Path: filepath.ToSlash(rel),
+			Size: info.Size(),
+			Mode: info.Mode()})
+		return nil
+	})
+	return
+}
+
+// opens a file of a snapshot. As in the other backends, the contents of a
+// symlink are its target.
+func (b PosixBackend) OpenFile(v *version, path string) (io.ReadCloser, error) {
+	src, closer, err := openSource(b.snapshotPath(v) + "/" + path)
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{src, closer}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
+
+// checks the index, and each snapshot against the checksums recorded when
+// it was committed. Snapshots committed before checksums were recorded get
+// them from their current contents when repairing.
+func (b PosixBackend) Fsck(repair bool) (problems []problem, err error) {
+	flock, err := lockIndex(b.snapshotsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	versions, problems, err := fsckIndexFile(b.snapshotsPath, func(v *version) (bool, error) {
+		return exists(b.snapshotPath(v))
+	}, repair)
+	if err != nil {
+		return
+	}
+
+	kept := map[string]bool{}
+	for i := range versions {
+		v := &versions[i]
+		kept[b.snapshotPath(v)] = true
+
+		manifest, err := readManifestFile(b.manifestPath(v))
+		if os.IsNotExist(err) {
+			problems = append(problems, problem{msg: "version " + v.id() + ": no checksums recorded", fixable: true})
+			if repair {
+				if err = b.writeManifest(v); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err != nil {
+			problems = append(problems, problem{msg: "version " + v.id() + ": " + err.Error()})
+			continue
+		}
+		actual, err := manifestOf(b.snapshotPath(v))
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, compareManifests(v, manifest, actual)...)
+	}
 
-		v := *NewVersionWithMeta(v_str, meta)
-		versions = append(versions, v)
+	removed, err := readRemovedFile(b.snapshotsPath)
+	if err != nil {
+		return
+	}
+	revisions, err := ioutil.ReadDir(b.snapshotsPath)
+	if err != nil {
+		return
+	}
+	for _, rev := range revisions {
+		if !rev.IsDir() || rev.Name() == "manifests" || rev.Name() == "metadata" {
+			continue
+		}
+		snapshots, err := ioutil.ReadDir(b.snapshotsPath + "/" + rev.Name())
+		if err != nil {
+			return nil, err
+		}
+		for _, snap := range snapshots {
+			path := b.snapshotsPath + "/" + rev.Name() + "/" + snap.Name()
+			if _, err := strconv.ParseInt(snap.Name(), 10, 64); err != nil || !snap.IsDir() || kept[path] {
+				continue
+			}
+			problems = append(problems, unindexedProblem("snapshot", path, removed))
+		}
 	}
 	return
 }
 
-func (b PosixBackend) Diff(v1 *version, v2 *version, obj string) (string, error) {
-	return "", AnError{"not yet"}
+// rebuilds the index from the snapshot folders and their sidecar files
+func (b PosixBackend) Reindex(dryRun bool) ([]version, error) {
+	return reindexFile(b.snapshotsPath, func() (found []version, err error) {
+		revisions, err := ioutil.ReadDir(b.snapshotsPath)
+		if err != nil {
+			return
+		}
+		for _, rev := range revisions {
+			if !rev.IsDir() || rev.Name() == "manifests" || rev.Name() == "metadata" {
+				continue
+			}
+			snapshots, err := ioutil.ReadDir(b.snapshotsPath + "/" + rev.Name())
+			if err != nil {
+				return nil, err
+			}
+			for _, snap := range snapshots {
+				v, ok := versionOfPath(rev.Name() + "/" + snap.Name())
+				if !ok || !snap.IsDir() {
+					continue
+				}
+				if v.meta, err = readSidecar(b.snapshotsPath, v); err != nil {
+					return nil, err
+				}
+				found = append(found, *v)
+			}
+		}
+		return
+	}, dryRun)
+}
+
+// migrates the index to the current format
+func (b PosixBackend) UpgradeIndex() (int, error) {
+	return upgradeIndexFile(b.snapshotsPath)
 }
@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"strings"
 	"testing"
+	"time"
 
 	"gopkg.in/ini.v1"
 
@@ -78,7 +78,7 @@ func TestPosixBackendCommit(t *testing.T) {
 	assert.Nil(t, err)
 
 	// commit everything that is ignored or untracked
-	v, err := backend.Commit(map[string]string{})
+	v, err := backend.Commit(metadata{})
 	assert.Nil(t, err)
 	assert.NotNil(t, v)
 
@@ -126,7 +126,7 @@ func TestPosixBackendCommitWithIgnore(t *testing.T) {
 	_, err = runCmd(path, "git commit -m committing_vio_ignored_files")
 	assert.Nil(t, err)
 
-	v, err := backend.Commit(map[string]string{})
+	v, err := backend.Commit(metadata{})
 	assert.Nil(t, err)
 	assert.NotNil(t, v)
 
@@ -170,7 +170,7 @@ func TestPosixBackendCheckout(t *testing.T) {
 	assert.Nil(t, err)
 
 	// commit everything that is ignored or untracked
-	v, err := backend.Commit(map[string]string{})
+	v, err := backend.Commit(metadata{})
 	assert.Nil(t, err)
 	assert.NotNil(t, v)
 
@@ -207,13 +207,13 @@ func TestPosixBackendCommitWithTags(t *testing.T) {
 	assert.Nil(t, err)
 
 	// commit everything that is ignored or untracked
-	v, err := backend.Commit(map[string]string{"foo": "bar", "hello": "goodbye"})
+	v, err := backend.Commit(metadata{"foo": "bar", "hello": "goodbye"})
 	assert.Nil(t, err)
 	assert.NotNil(t, v)
 
 	contents, err := ioutil.ReadFile(path + "/.snapshots/index")
 	assert.Nil(t, err)
-	assert.Equal(t, strings.TrimSpace(string(contents)), fmt.Sprintf("%v", v))
+	assert.Equal(t, string(contents), indexOf(t, v))
 }
 
 func TestPosixBackendAddVersionToIndex(t *testing.T) {
@@ -226,7 +226,7 @@ func TestPosixBackendAddVersionToIndex(t *testing.T) {
 	assert.NotNil(t, v1)
 	v2 := NewVersion(v2_str)
 	assert.NotNil(t, v2)
-	meta := map[string]string{"foo": "bar", "hello": "goodbye"}
+	meta := metadata{"foo": "bar", "hello": "goodbye"}
 	v3_str := "3943943128#5635869343"
 	v3 := NewVersionWithMeta(v3_str, meta)
 	assert.NotNil(t, v3)
@@ -299,3 +299,127 @@ func TestPosixBackendGetVersions(t *testing.T) {
 	assert.Equal(t, vs[1].revision, v2.revision)
 	assert.Equal(t, vs[1].timestamp, v2.timestamp)
 }
+
+func TestPosixBackendDiff(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	backend := getNewPosixBackend(t, path)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("one\ntwo\nthree\n"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/toz", []byte("ok"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/bin", []byte("a\x00b"), 0644)
+	assert.Nil(t, err)
+
+	v1, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+	assert.NotNil(t, v1)
+
+	files, err := backend.ListFiles(v1)
+	assert.Nil(t, err)
+	assert.Equal(t, len(files), 3)
+
+	err = ioutil.WriteFile(path+"/bar", []byte("one\n2\nthree\n"), 0644)
+	assert.Nil(t, err)
+	err = os.Remove(path + "/toz")
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/bin", []byte("a\x00c"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/new", []byte("new"), 0644)
+	assert.Nil(t, err)
+
+	// two snapshots of the same commit can't have the same timestamp
+	time.Sleep(time.Second)
+
+	v2, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+	assert.NotNil(t, v2)
+
+	d, err := backend.Diff(v1, v2, "")
+	assert.Nil(t, err)
+	assert.Contains(t, d, "modified: bar\n")
+	assert.Contains(t, d, "removed:  toz (2 bytes)\n")
+	assert.Contains(t, d, "added:    new (3 bytes)\n")
+	assert.Contains(t, d, "modified: bin\n")
+	assert.Contains(t, d, "Binary files bin differ (3 bytes -> 3 bytes)\n")
+	assert.Contains(t, d, "@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n")
+
+	d, err = backend.Diff(v1, v2, "bar")
+	assert.Nil(t, err)
+	assert.NotContains(t, d, "toz")
+
+	_, err = backend.Diff(v1, NewVersion("1234567890#1405544146"), "")
+	assert.NotNil(t, err)
+}
+
+func TestPosixBackendIncrementalCommit(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+
+	createAndSeedTestRepo(t, path, []string{})
+
+	opts := ini.Empty()
+	opts.Section("").Key("repo_path").SetValue(path)
+	opts.Section("").Key("snapshots_path").SetValue(path + "/.snapshots")
+	opts.Section("").Key("backend_type").SetValue("posix")
+	opts.Section("").Key("incremental").SetValue("ture")
+	_, err = InstantiateBackend(opts)
+	assert.NotNil(t, err)
+	opts.Section("").Key("incremental").SetValue("true")
+	backend, err := InstantiateBackend(opts)
+	assert.Nil(t, err)
+
+	err = backend.Init()
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/input", []byte("a large dataset"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(path+"/output", []byte("one"), 0644)
+	assert.Nil(t, err)
+
+	v1, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(path+"/output", []byte("two"), 0644)
+	assert.Nil(t, err)
+
+	time.Sleep(time.Second)
+
+	v2, err := backend.Commit(metadata{})
+	assert.Nil(t, err)
+
+	snap1 := fmt.Sprintf("%s/.snapshots/%s/%d", path, v1.revision, v1.timestamp.Unix())
+	snap2 := fmt.Sprintf("%s/.snapshots/%s/%d", path, v2.revision, v2.timestamp.Unix())
+
+	// unchanged files are shared with the previous snapshot
+	in1, err := os.Stat(snap1 + "/input")
+	assert.Nil(t, err)
+	in2, err := os.Stat(snap2 + "/input")
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(in1, in2))
+
+	out1, err := os.Stat(snap1 + "/output")
+	assert.Nil(t, err)
+	out2, err := os.Stat(snap2 + "/output")
+	assert.Nil(t, err)
+	assert.False(t, os.SameFile(out1, out2))
+
+	err = os.Remove(path + "/input")
+	assert.Nil(t, err)
+	err = backend.Checkout(v1)
+	assert.Nil(t, err)
+
+	contents, err := ioutil.ReadFile(path + "/output")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "one")
+	contents, err = ioutil.ReadFile(path + "/input")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "a large dataset")
+}
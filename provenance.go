@@ -0,0 +1,270 @@
+package vio
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// the version of vio, recorded in the provenance of every commit
+const Version = "0.1.0"
+
+// A Collector gathers information about the environment in which a version
+// is committed. The keys it returns are added to the metadata of the
+// version, prefixed by the name the collector was registered with.
+type Collector interface {
+	Collect(opts *ini.File) (map[string]string, error)
+}
+
+// CollectorFunc allows to use an ordinary function as a Collector
+type CollectorFunc func(opts *ini.File) (map[string]string, error)
+
+func (f CollectorFunc) Collect(opts *ini.File) (map[string]string, error) {
+	return f(opts)
+}
+
+// environment variables recorded when no allowlist is configured
+var defaultEnvAllowlist = []string{"OMP_NUM_THREADS", "MKL_NUM_THREADS", "CUDA_VISIBLE_DEVICES"}
+
+var collectors = map[string]Collector{
+	"host":   CollectorFunc(collectHost),
+	"cpu":    CollectorFunc(collectCPU),
+	"memory": CollectorFunc(collectMemory),
+	"env":    CollectorFunc(collectEnv),
+	"git":    CollectorFunc(collectGit),
+	"vio":    CollectorFunc(collectVio),
+}
+
+// RegisterCollector makes a collector available under the given name,
+// replacing any collector previously registered with it. Registered
+// collectors run on every commit, unless the 'collectors' key of the
+// '[provenance]' section of the configuration lists which ones to run.
+func RegisterCollector(name string, c Collector) {
+	collectors[name] = c
+}
+
+// runs the enabled collectors and the command collectors declared in the
+// configuration. Command collectors are sections of the form:
+//
+//	[collector.modules]
+//	command = module list
+//
+// whose output is stored in '<name>.output'. Collectors that fail don't
+// prevent the commit; their error is stored in '<name>.error' instead.
+func collectProvenance(opts *ini.File) (meta map[string]string) {
+	meta = map[string]string{}
+
+	record := func(name string, c Collector) {
+		values, err := c.Collect(opts)
+		if err != nil {
+			meta[name+".error"] = err.Error()
+			return
+		}
+		for k, v := range values {
+			meta[name+"."+k] = v
+		}
+	}
+
+	for _, name := range enabledCollectors(opts) {
+		c, ok := collectors[name]
+		if !ok {
+			meta[name+".error"] = "unknown collector"
+			continue
+		}
+		record(name, c)
+	}
+
+	for _, section := range opts.Sections() {
+		if !strings.HasPrefix(section.Name(), "collector.") {
+			continue
+		}
+		name := strings.TrimPrefix(section.Name(), "collector.")
+		record(name, commandCollector(section.Key("command").String()))
+	}
+
+	return
+}
+
+// adds provenance to the metadata of a version. Keys given by the user are
+// never overwritten.
+func addProvenance(meta metadata, opts *ini.File) {
+	for k, v := range collectProvenance(opts) {
+		if _, ok := meta[k]; !ok {
+			meta[k] = v
+		}
+	}
+}
+
+func enabledCollectors(opts *ini.File) (names []string) {
+	section := opts.Section("provenance")
+	if section.HasKey("collectors") {
+		return listValue(section.Key("collectors").String())
+	}
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// splits a comma-separated configuration value
+func listValue(value string) (items []string) {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// returns a collector that stores the output of a shell command
+func commandCollector(command string) Collector {
+	return CollectorFunc(func(opts *ini.File) (map[string]string, error) {
+		if strings.TrimSpace(command) == "" {
+			return nil, AnError{"Expecting key 'command' in collector section."}
+		}
+		out, err := exec.Command("sh", "-c", command).Output()
+		if err != nil {
+			return nil, AnError{"Command '" + command + "' failed: " + err.Error()}
+		}
+		return map[string]string{"output": strings.TrimSpace(string(out))}, nil
+	})
+}
+
+func collectHost(opts *ini.File) (meta map[string]string, err error) {
+	meta = map[string]string{"os": runtime.GOOS, "arch": runtime.GOARCH}
+
+	if meta["name"], err = os.Hostname(); err != nil {
+		return
+	}
+
+	if u, err := user.Current(); err == nil {
+		meta["user"] = u.Username
+	} else {
+		meta["user"] = os.Getenv("USER")
+	}
+
+	if out, err := exec.Command("uname", "-r").Output(); err == nil {
+		meta["kernel"] = strings.TrimSpace(string(out))
+	}
+
+	if release, err := readKeyValues("/etc/os-release", "="); err == nil {
+		if name, ok := release["PRETTY_NAME"]; ok {
+			meta["distribution"] = strings.Trim(name, `"`)
+		}
+	}
+	return
+}
+
+func collectCPU(opts *ini.File) (meta map[string]string, err error) {
+	meta = map[string]string{"count": strconv.Itoa(runtime.NumCPU())}
+
+	if info, err := readKeyValues("/proc/cpuinfo", ":"); err == nil {
+		if model, ok := info["model name"]; ok {
+			meta["model"] = model
+		}
+	} else if out, err := exec.Command("sysctl", "-n", "machdep.cpu.brand_string").Output(); err == nil {
+		meta["model"] = strings.TrimSpace(string(out))
+	}
+	return
+}
+
+func collectMemory(opts *ini.File) (meta map[string]string, err error) {
+	meta = map[string]string{}
+
+	if info, err := readKeyValues("/proc/meminfo", ":"); err == nil {
+		if total, ok := info["MemTotal"]; ok {
+			meta["total_kb"] = strings.TrimSpace(strings.TrimSuffix(total, "kB"))
+		}
+	} else if out, err := exec.Command("sysctl", "-n", "hw.memsize").Output(); err == nil {
+		bytes, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+		if err == nil {
+			meta["total_kb"] = strconv.FormatInt(bytes/1024, 10)
+		}
+	}
+	return
+}
+
+// records the environment variables listed in the 'env' key of the
+// '[provenance]' section of the configuration
+func collectEnv(opts *ini.File) (meta map[string]string, err error) {
+	meta = map[string]string{}
+
+	allowlist := defaultEnvAllowlist
+	if section := opts.Section("provenance"); section.HasKey("env") {
+		allowlist = listValue(section.Key("env").String())
+	}
+	for _, name := range allowlist {
+		if value, ok := os.LookupEnv(name); ok {
+			meta[name] = value
+		}
+	}
+	return
+}
+
+func collectGit(opts *ini.File) (meta map[string]string, err error) {
+	meta = map[string]string{}
+
+	if meta["branch"], err = GetCurrentBranch("."); err != nil {
+		return
+	}
+	// not having a remote is fine
+	if url, err := GetRemoteURL("."); err == nil && url != "" {
+		meta["remote"] = withoutUserinfo(url)
+	}
+	return
+}
+
+// removes the user and credentials that a remote URL may carry, so that
+// they don't end up in the metadata of a version. Besides URLs, git accepts
+// the scp-like 'user@host:path' syntax, whose user is removed as well.
+func withoutUserinfo(remote string) string {
+	if !strings.Contains(remote, "://") {
+		at, colon := strings.Index(remote, "@"), strings.Index(remote, ":")
+		if at >= 0 && colon > at {
+			return remote[at+1:]
+		}
+		return remote
+	}
+	u, err := url.Parse(remote)
+	if err != nil || u.User == nil {
+		return remote
+	}
+	u.User = nil
+	return u.String()
+}
+
+func collectVio(opts *ini.File) (map[string]string, error) {
+	return map[string]string{"version": Version}, nil
+}
+
+// reads a file made of 'key<sep>value' lines, keeping the first value of
+// each key
+func readKeyValues(path string, sep string) (values map[string]string, err error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	values = map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(contents)))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), sep, 2)
+		if len(fields) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(fields[0])
+		if _, ok := values[key]; !ok {
+			values[key] = strings.TrimSpace(fields[1])
+		}
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,279 @@
+package vio
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// every snapshot has a sidecar file with its line of the index, in
+// '<snapshots>/metadata/<revision>/<timestamp>', so that the index can be
+// rebuilt from storage if it is lost
+func sidecarPath(snapsPath string, v *version) string {
+	return fmt.Sprintf("%s/metadata/%s/%d", snapsPath, v.revision, v.timestamp.Unix())
+}
+
+func writeSidecar(snapsPath string, v *version) (err error) {
+	path := sidecarPath(snapsPath, v)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	return ioutil.WriteFile(path, []byte(fmt.Sprintf("%v\n", v)), 0644)
+}
+
+// reads the metadata of a version from its sidecar file. Versions committed
+// before sidecars existed, or whose sidecar is malformed, get nil metadata.
+func readSidecar(snapsPath string, v *version) (meta metadata, err error) {
+	contents, err := ioutil.ReadFile(sidecarPath(snapsPath, v))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	return parseSidecar(contents, v), nil
+}
+
+func parseSidecar(contents []byte, v *version) metadata {
+	meta, err := sidecarMeta(contents, v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	return meta
+}
+
+func sidecarMeta(contents []byte, v *version) (metadata, error) {
+	stored, err := parseIndexLine(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return nil, err
+	}
+	if stored.id() != v.id() {
+		return nil, AnError{"Metadata of version " + stored.id() + " stored for " + v.id()}
+	}
+	return stored.meta, nil
+}
+
+func removeSidecar(snapsPath string, v *version) (err error) {
+	if err = os.Remove(sidecarPath(snapsPath, v)); os.IsNotExist(err) {
+		return nil
+	}
+	return
+}
+
+// parses the '<revision>/<timestamp>' part of a path, as used to lay out
+// snapshots, manifests and sidecars
+func versionOfPath(path string) (v *version, ok bool) {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	if len(parts) < 2 {
+		return nil, false
+	}
+	if _, err := strconv.ParseInt(parts[len(parts)-1], 10, 64); err != nil {
+		return nil, false
+	}
+	v, err := parseVersion(parts[len(parts)-2]+"#"+parts[len(parts)-1], nil)
+	return v, err == nil
+}
+
+// versions removed with 'vio rm' keep their data in storage until 'vio gc'
+// deletes it, so reindexing has to leave them out. They are listed in
+// '<snapshots>/removed' (the 'removed' object with S3), one ID per line,
+// until they are added again.
+
+// returns the list of removed versions with the given ones added, and the
+// one given in readded (if any) dropped
+func updateRemoved(contents []byte, removed []*version, readded *version) []byte {
+	var buf bytes.Buffer
+	seen := map[string]bool{}
+	for _, id := range strings.Split(string(contents), "\n") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] || (readded != nil && id == readded.id()) {
+			continue
+		}
+		seen[id] = true
+		buf.WriteString(id + "\n")
+	}
+	for _, v := range removed {
+		if !seen[v.id()] {
+			seen[v.id()] = true
+			buf.WriteString(v.id() + "\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+func parseRemoved(contents []byte) map[string]bool {
+	removed := map[string]bool{}
+	for _, id := range strings.Split(string(contents), "\n") {
+		if id = strings.TrimSpace(id); id != "" {
+			removed[id] = true
+		}
+	}
+	return removed
+}
+
+// updates the list of removed versions of the backends that keep the index
+// in the local filesystem. The caller has to hold the index lock.
+func updateRemovedFile(snapsPath string, removed []*version, readded *version) error {
+	filename := snapsPath + "/removed"
+	contents, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		if len(removed) == 0 {
+			return nil
+		}
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	updated := updateRemoved(contents, removed, readded)
+	if bytes.Equal(updated, contents) {
+		return nil
+	}
+	return ioutil.WriteFile(filename, updated, 0644)
+}
+
+// reads the list of removed versions of the backends that keep the index in
+// the local filesystem
+func readRemovedFile(snapsPath string) (map[string]bool, error) {
+	contents, err := ioutil.ReadFile(snapsPath + "/removed")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return parseRemoved(contents), nil
+}
+
+// whether GC can delete the data stored in the given path, which isn't
+// referenced by the index. The data of a version is only deleted if it was
+// removed with 'vio rm': any other version missing from the index could be
+// there because the index was corrupted or lost, and is left for reindexing
+// to recover.
+func collectable(path string, removed map[string]bool) bool {
+	v, ok := versionOfPath(path)
+	return !ok || removed[v.id()]
+}
+
+// leaves the removed versions out of those found in storage
+func withoutRemoved(found []version, removed map[string]bool) (kept []version) {
+	kept = []version{}
+	for _, v := range found {
+		if !removed[v.id()] {
+			kept = append(kept, v)
+		}
+	}
+	return
+}
+
+// returns the index for the versions found in storage, ordered by
+// timestamp. Versions found without metadata get the one they have in the
+// old index, if any. The format of the old index is kept, unless it is
+// empty or can't be read, in which case the current one is used.
+func rebuildIndex(found []version, old []byte) ([]byte, error) {
+	idx, err := decodeIndex(old)
+	if err != nil || len(idx.entries) == 0 {
+		idx = newIndex()
+	}
+	previous := map[string]metadata{}
+	for _, v := range idx.versions() {
+		previous[v.id()] = v.meta
+	}
+	rebuilt := &Index{format: idx.format}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].timestamp.Equal(found[j].timestamp) {
+			return found[i].revision < found[j].revision
+		}
+		return found[i].timestamp.Before(found[j].timestamp)
+	})
+	for i := range found {
+		v := &found[i]
+		if v.meta == nil {
+			v.meta = previous[v.id()]
+		}
+		if v.meta == nil {
+			v.meta = metadata{}
+		}
+		if err := rebuilt.add(v); err != nil {
+			return nil, err
+		}
+	}
+	return rebuilt.encode()
+}
+
+// rebuilds the index of the backends that keep it in the local filesystem
+// out of the versions found by the given function, which is called holding
+// the index lock. The previous index is kept in '<snapshots>/index.bak'.
+func reindexFile(snapsPath string, find func() ([]version, error), dryRun bool) (versions []version, err error) {
+	filename := snapsPath + "/index"
+	if _, err = os.Stat(filename); os.IsNotExist(err) && !dryRun {
+		if err = ioutil.WriteFile(filename, []byte{}, 0644); err != nil {
+			return
+		}
+	}
+	if !dryRun {
+		flock, err := lockIndex(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer flock.Unlock()
+	}
+	old, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	found, err := find()
+	if err != nil {
+		return
+	}
+	removed, err := readRemovedFile(snapsPath)
+	if err != nil {
+		return
+	}
+	found = withoutRemoved(found, removed)
+	contents, err := rebuildIndex(found, old)
+	if err != nil {
+		return
+	}
+	if versions, err = parseIndex(contents); err != nil || dryRun {
+		return
+	}
+	if err = ioutil.WriteFile(snapsPath+"/index.bak", old, 0644); err != nil {
+		return
+	}
+	err = ioutil.WriteFile(filename, contents, 0644)
+	return
+}
+
+// rebuilds the index from the snapshots in storage. With dryRun, only
+// shows the versions that the rebuilt index would have.
+func Reindex(dryRun bool) (report string, err error) {
+	b, err := load()
+	if err != nil {
+		return
+	}
+	versions, err := b.Reindex(dryRun)
+	if err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	withoutMeta := 0
+	for _, v := range versions {
+		if len(v.meta) == 0 {
+			buf.WriteString(v.id() + " (no metadata)\n")
+			withoutMeta++
+		} else {
+			buf.WriteString(v.id() + " " + v.meta.str("message") + "\n")
+		}
+	}
+	verb := "indexed"
+	if dryRun {
+		verb = "would be indexed"
+	}
+	buf.WriteString(fmt.Sprintf("%d versions %s, %d without metadata\n", len(versions), verb, withoutMeta))
+	return buf.String(), nil
+}
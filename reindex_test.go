@@ -0,0 +1,150 @@
+package vio
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testReindex(t *testing.T, backend string) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	assert.Nil(t, Init(".snapshots", backend))
+
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte("1"), 0644))
+	assert.Nil(t, Commit("first", `{"threads": "8"}`))
+	time.Sleep(time.Second)
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte("2"), 0644))
+	assert.Nil(t, Commit("second", "{}"))
+
+	original, err := ioutil.ReadFile(".snapshots/index")
+	assert.Nil(t, err)
+	logstr, err := Log()
+	assert.Nil(t, err)
+
+	// malformed lines don't hide the rest of the history
+	assert.Nil(t, ioutil.WriteFile(".snapshots/index", append([]byte("garbage\n"), original...), 0644))
+	corrupted, err := Log()
+	assert.Nil(t, err)
+	assert.Equal(t, corrupted, logstr)
+
+	assert.Nil(t, os.Remove(".snapshots/index"))
+
+	report, err := Reindex(true)
+	assert.Nil(t, err)
+	assert.Contains(t, report, " first\n")
+	assert.Contains(t, report, " second\n")
+	assert.True(t, strings.HasSuffix(report, "2 versions would be indexed, 0 without metadata\n"))
+	_, err = os.Stat(".snapshots/index")
+	assert.True(t, os.IsNotExist(err))
+
+	report, err = Reindex(false)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(report, "2 versions indexed, 0 without metadata\n"))
+	rebuilt, err := ioutil.ReadFile(".snapshots/index")
+	assert.Nil(t, err)
+	assert.Equal(t, string(rebuilt), string(original))
+}
+
+func TestPosixReindex(t *testing.T) {
+	testReindex(t, "posix")
+}
+
+func TestCasReindex(t *testing.T) {
+	testReindex(t, "cas")
+}
+
+func TestGitReindex(t *testing.T) {
+	testReindex(t, "git")
+}
+
+// the lines of a multi-line message end up in the body of the commit, along
+// with the metadata
+func TestGitReindexMultilineMessage(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	assert.Nil(t, Init(".snapshots", "git"))
+
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte("1"), 0644))
+	assert.Nil(t, Commit("first\nwrapped\n\nwith details\nover two lines", `{"threads": "8"}`))
+	time.Sleep(time.Second)
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte("2"), 0644))
+	assert.Nil(t, Commit("second", `{"threads": "16"}`))
+
+	original, err := ioutil.ReadFile(".snapshots/index")
+	assert.Nil(t, err)
+	assert.Nil(t, os.Remove(".snapshots/index"))
+	report, err := Reindex(false)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(report, "2 versions indexed, 0 without metadata\n"))
+	rebuilt, err := ioutil.ReadFile(".snapshots/index")
+	assert.Nil(t, err)
+	assert.Equal(t, string(rebuilt), string(original))
+}
+
+// versions committed before sidecars existed keep the metadata they have in
+// the index, if any
+func TestReindexWithoutSidecars(t *testing.T) {
+	testReindex(t, "posix")
+	assert.Nil(t, os.RemoveAll(".snapshots/metadata"))
+
+	report, err := Reindex(false)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(report, "2 versions indexed, 0 without metadata\n"))
+
+	assert.Nil(t, ioutil.WriteFile(".snapshots/index", []byte{}, 0644))
+	report, err = Reindex(false)
+	assert.Nil(t, err)
+	assert.Contains(t, report, " (no metadata)\n")
+	assert.True(t, strings.HasSuffix(report, "2 versions indexed, 2 without metadata\n"))
+	logstr, err := Log()
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Count(logstr, "\n"), 2)
+}
+
+// versions removed with 'vio rm' keep their data until 'vio gc', but don't
+// come back when reindexing
+func testReindexAfterRemove(t *testing.T, backend string) {
+	testReindex(t, backend)
+	b, err := load()
+	assert.Nil(t, err)
+	vs, err := b.GetVersions()
+	assert.Nil(t, err)
+	assert.Nil(t, b.Remove([]*version{&vs[0]}))
+
+	report, err := Reindex(false)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(report, "1 versions indexed, 0 without metadata\n"))
+	reindexed, err := b.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(reindexed), 1)
+	assert.Equal(t, reindexed[0].id(), vs[1].id())
+}
+
+func TestPosixReindexAfterRemove(t *testing.T) {
+	testReindexAfterRemove(t, "posix")
+}
+
+func TestCasReindexAfterRemove(t *testing.T) {
+	testReindexAfterRemove(t, "cas")
+}
+
+func TestGitReindexAfterRemove(t *testing.T) {
+	testReindexAfterRemove(t, "git")
+}
+
+func TestUpdateRemoved(t *testing.T) {
+	v1 := NewVersion("1234567#1405544146")
+	v2 := NewVersion("1234567#1405544147")
+	removed := updateRemoved(nil, []*version{v1, v2}, nil)
+	assert.Equal(t, string(removed), v1.id()+"\n"+v2.id()+"\n")
+	assert.Equal(t, string(updateRemoved(removed, []*version{v1}, nil)), string(removed))
+	assert.Equal(t, string(updateRemoved(removed, nil, v1)), v2.id()+"\n")
+	assert.Equal(t, parseRemoved(removed), map[string]bool{v1.id(): true, v2.id(): true})
+}
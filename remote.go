@@ -0,0 +1,221 @@
+package vio
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// remotes are stored in the configuration as sections named
+// 'remote.<name>', which hold the options of the backend that stores them.
+// For example:
+//
+//	[remote.origin]
+//	backend_type = posix
+//	snapshots_path = /shared/myproject/snapshots
+const remotePrefix = "remote."
+
+var remoteName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+// guesses the backend of a snapshots folder from its layout
+func detectBackend(path string) (string, error) {
+	exists := func(p string) bool {
+		_, err := os.Stat(path + "/" + p)
+		return err == nil
+	}
+	switch {
+	case !exists("index"):
+		return "", AnError{"No vio snapshots in " + path}
+	case exists("git/lfs"):
+		return "git-lfs", nil
+	case exists("git"):
+		return "git", nil
+	case exists("objects"):
+		return "cas", nil
+	}
+	return "posix", nil
+}
+
+// returns the configuration of the backend of a remote. If backendType is
+// empty, it is guessed from the folder given by location.
+func remoteOptions(location string, backendType string, options map[string]string) (opts *ini.File, err error) {
+	if backendType == "" {
+		if location == "" {
+			return nil, AnError{"Expecting the path or the backend type of the remote"}
+		}
+		if backendType, err = detectBackend(location); err != nil {
+			return
+		}
+	}
+	opts = ini.Empty()
+	if location != "" {
+		opts.Section("").Key("snapshots_path").SetValue(location)
+	}
+	opts.Section("").Key("backend_type").SetValue(backendType)
+	for k, v := range options {
+		opts.Section("").Key(k).SetValue(v)
+	}
+	return
+}
+
+// instantiates the backend of a remote, which has to be initialized
+func openRemote(opts *ini.File, name string) (b Backend, err error) {
+	if !opts.HasSection(remotePrefix + name) {
+		return nil, AnError{"Unknown remote '" + name + "'"}
+	}
+	ropts := ini.Empty()
+	for _, k := range opts.Section(remotePrefix + name).Keys() {
+		ropts.Section("").Key(k.Name()).SetValue(k.Value())
+	}
+	if b, err = InstantiateBackend(ropts); err != nil {
+		return
+	}
+	if !b.IsInitialized() {
+		return nil, AnError{"Remote '" + name + "' is not initialized"}
+	}
+	return
+}
+
+// adds a remote to the configuration. The remote is either a snapshots
+// folder, whose backend is guessed unless backendType is given, or a
+// backend configured through options (e.g. an S3 bucket). If init is true,
+// the remote is initialized, with the posix backend by default; otherwise,
+// it has to be initialized already.
+func AddRemote(name string, location string, backendType string,
+	options map[string]string, init bool) (err error) {
+
+	if !remoteName.MatchString(name) {
+		return AnError{"Invalid remote name '" + name + "'"}
+	}
+	opts, err := loadConfig()
+	if err != nil {
+		return
+	}
+	if opts.HasSection(remotePrefix + name) {
+		return AnError{"Remote '" + name + "' already exists"}
+	}
+
+	if init && backendType == "" {
+		backendType = "posix"
+	}
+	ropts, err := remoteOptions(location, backendType, options)
+	if err != nil {
+		return
+	}
+	b, err := InstantiateBackend(ropts)
+	if err != nil {
+		return
+	}
+	if init {
+		if err = b.Init(); err != nil {
+			return
+		}
+	} else if !b.IsInitialized() {
+		return AnError{"No vio repository at remote '" + name + "'"}
+	}
+
+	s := opts.Section(remotePrefix + name)
+	for _, k := range ropts.Section("").Keys() {
+		if k.Name() != "repo_path" {
+			s.Key(k.Name()).SetValue(k.Value())
+		}
+	}
+	return opts.SaveTo(".vioconfig")
+}
+
+func RemoveRemote(name string) (err error) {
+	opts, err := loadConfig()
+	if err != nil {
+		return
+	}
+	if !opts.HasSection(remotePrefix + name) {
+		return AnError{"Unknown remote '" + name + "'"}
+	}
+	opts.DeleteSection(remotePrefix + name)
+	return opts.SaveTo(".vioconfig")
+}
+
+// lists remotes along with their backend and location
+func ListRemotes() (remotes string, err error) {
+	opts, err := loadConfig()
+	if err != nil {
+		return
+	}
+	names := []string{}
+	for _, name := range opts.SectionStrings() {
+		if strings.HasPrefix(name, remotePrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		s := opts.Section(name)
+		location := s.Key("snapshots_path").String()
+		if s.Key("backend_type").String() == "s3" {
+			location = "s3://" + s.Key("s3_bucket").String() + "/" + s.Key("s3_prefix").String()
+		}
+		fmt.Fprintf(&buf, "%s %s %s\n",
+			strings.TrimPrefix(name, remotePrefix), s.Key("backend_type").String(), location)
+	}
+	return buf.String(), nil
+}
+
+// copies the given versions, or all of them if none is given, to a remote
+func Push(name string, refs []string) (report string, err error) {
+	opts, err := loadConfig()
+	if err != nil {
+		return
+	}
+	b, err := InstantiateBackend(opts)
+	if err != nil {
+		return
+	}
+	r, err := openRemote(opts, name)
+	if err != nil {
+		return
+	}
+	return syncVersions(b, r, refs, "pushed")
+}
+
+// copies the given versions of a remote, or all of them if none is given
+func Pull(name string, refs []string) (report string, err error) {
+	opts, err := loadConfig()
+	if err != nil {
+		return
+	}
+	b, err := InstantiateBackend(opts)
+	if err != nil {
+		return
+	}
+	r, err := openRemote(opts, name)
+	if err != nil {
+		return
+	}
+	return syncVersions(r, b, refs, "pulled")
+}
+
+// initializes a repository and pulls all the versions of the snapshots
+// folder at location, which is added as the 'origin' remote
+func Clone(location string, snapsPath string, backendType string) (report string, err error) {
+	if _, err = os.Stat(".vioconfig"); err == nil {
+		return "", AnError{"Repository already initialized"}
+	}
+	// fail before initializing anything if the source is not usable
+	if _, err = detectBackend(location); err != nil {
+		return
+	}
+	if err = Init(snapsPath, backendType); err != nil {
+		return
+	}
+	if err = AddRemote("origin", location, "", nil, false); err != nil {
+		return
+	}
+	return Pull("origin", nil)
+}
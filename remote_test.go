@@ -0,0 +1,140 @@
+package vio
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRemote(t *testing.T, local string, remote string, clone string) {
+	shared, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, err)
+
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	assert.Nil(t, Init(".snapshots", local))
+
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte("first"), 0644))
+	assert.Nil(t, os.Symlink("results.txt", "latest.txt"))
+	assert.Nil(t, Commit("first", "{}"))
+	time.Sleep(time.Second)
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte("second"), 0755))
+	assert.Nil(t, Commit("second", `{"threads": "64"}`))
+	assert.Nil(t, Tag("baseline", "@~1", false))
+	assert.Nil(t, Annotate("@", map[string]string{"valid": "false"}, nil))
+
+	assert.NotNil(t, AddRemote("origin", shared+"/snapshots", "", nil, false))
+	assert.Nil(t, AddRemote("origin", shared+"/snapshots", remote, nil, true))
+	assert.NotNil(t, AddRemote("origin", shared+"/snapshots", remote, nil, false))
+	assert.NotNil(t, AddRemote("bad name", shared+"/snapshots", remote, nil, false))
+	remotes, err := ListRemotes()
+	assert.Nil(t, err)
+	assert.Equal(t, remotes, "origin "+remote+" "+shared+"/snapshots\n")
+
+	_, err = Push("upstream", nil)
+	assert.NotNil(t, err)
+
+	report, err := Push("origin", []string{"@~1"})
+	assert.Nil(t, err)
+	assert.Contains(t, report, "1 versions pushed, 0 already present\n")
+	report, err = Push("origin", nil)
+	assert.Nil(t, err)
+	assert.Contains(t, report, " second\n1 versions pushed, 1 already present\n")
+	report, err = Push("origin", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, report, "0 versions pushed, 2 already present\n")
+
+	logstr, err := LogWithOptions(LogOptions{Format: "full"})
+	assert.Nil(t, err)
+
+	// a teammate clones the project and its versions
+	other, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, err)
+	_, err = runCmd(other, "git clone --quiet "+path+" .")
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(other))
+	report, err = Clone(shared+"/snapshots", ".snapshots", clone)
+	assert.Nil(t, err)
+	assert.Contains(t, report, "2 versions pulled, 0 already present\n")
+	_, err = Clone(shared+"/snapshots", ".snapshots", clone)
+	assert.NotNil(t, err)
+
+	clonedLog, err := LogWithOptions(LogOptions{Format: "full"})
+	assert.Nil(t, err)
+	assert.Equal(t, clonedLog, logstr)
+
+	b, err := load()
+	assert.Nil(t, err)
+	for ref, contents := range map[string]string{"baseline": "first", "@": "second"} {
+		v, err := resolveVersion(b, ref)
+		assert.Nil(t, err)
+		files, err := b.ListFiles(v)
+		assert.Nil(t, err)
+		for _, f := range files {
+			r, err := b.OpenFile(v, f.Path)
+			assert.Nil(t, err)
+			data, err := ioutil.ReadAll(r)
+			r.Close()
+			assert.Nil(t, err)
+			switch f.Path {
+			case "results.txt":
+				assert.Equal(t, string(data), contents)
+			case "latest.txt":
+				assert.True(t, f.Mode&os.ModeSymlink != 0)
+				assert.Equal(t, string(data), "results.txt")
+			}
+		}
+	}
+
+	report, err = Pull("origin", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, report, "0 versions pulled, 2 already present\n")
+
+	assert.Nil(t, RemoveRemote("origin"))
+	assert.NotNil(t, RemoveRemote("origin"))
+	_, err = Pull("origin", nil)
+	assert.NotNil(t, err)
+}
+
+func TestRemotePosixToGit(t *testing.T) {
+	testRemote(t, "posix", "git", "cas")
+}
+
+func TestRemoteGitLfsToCas(t *testing.T) {
+	testRemote(t, "git-lfs", "cas", "posix")
+}
+
+func TestRemoteCasToPosix(t *testing.T) {
+	testRemote(t, "cas", "posix", "git-lfs")
+}
+
+func TestSyncConflicts(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+
+	assert.Nil(t, os.Mkdir(path+"/src", 0755))
+	assert.Nil(t, os.Mkdir(path+"/dst", 0755))
+	src := getNewPosixBackend(t, path+"/src")
+	dst := getNewPosixBackend(t, path+"/dst")
+	assert.Nil(t, src.Init())
+	assert.Nil(t, dst.Init())
+
+	v := NewVersionWithMeta("1234567#1448281434", metadata{"message": "mine"})
+	assert.Nil(t, addVersionToIndex(v, path+"/src/.snapshots/index"))
+	assert.Nil(t, addVersionToIndex(NewVersion("1234567#1448300000"), path+"/src/.snapshots/index"))
+	v.meta["message"] = "theirs"
+	assert.Nil(t, addVersionToIndex(v, path+"/dst/.snapshots/index"))
+
+	_, err = syncVersions(src, dst, nil, "copied")
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasSuffix(err.Error(), "\n  1234567#1448281434"))
+	vs, err := dst.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(vs), 1)
+}
@@ -0,0 +1,205 @@
+package vio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// returns the command that produced a version: the one executed by 'vio
+// run', or a shell command given in the 'cmd' key of its metadata. The
+// arguments can be stored as a list or as a JSON string.
+func recordedCommand(meta metadata) (argv []string, err error) {
+	if _, ok := meta["run.argv"]; ok {
+		s := meta.str("run.argv")
+		if err = json.Unmarshal([]byte(s), &argv); err != nil || len(argv) == 0 {
+			return nil, AnError{"Malformed 'run.argv' in metadata: " + s}
+		}
+		return
+	}
+	if s := strings.TrimSpace(meta.str("cmd")); s != "" {
+		return []string{"sh", "-c", s}, nil
+	}
+	return nil, AnError{"No command recorded in metadata, expecting 'run.argv' or 'cmd'"}
+}
+
+// whether a file of a snapshot is one of the given outputs, which can be
+// files or folders
+func isOutput(path string, outputs []string) bool {
+	if path == RunStdout || path == RunStderr {
+		return true
+	}
+	for _, o := range outputs {
+		o = strings.TrimSuffix(filepath.ToSlash(filepath.Clean(o)), "/")
+		if path == o || strings.HasPrefix(path, o+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return AnError{"git " + args[0] + " failed: " + strings.TrimSpace(string(out))}
+	}
+	return nil
+}
+
+// re-executes the command that produced a version, in a temporary git
+// worktree at its revision, where the files of its snapshot are restored
+// except for the given outputs (and the output captured by 'vio run'). The
+// results are committed as a new version, with the id of the original in
+// the 'reproduces' key, and compared file by file with the original ones.
+// Returns an error if an output differs or is missing, or if none of the
+// given outputs is in the snapshot, since nothing would be checked.
+func Reproduce(ref string, outputs []string) (report string, err error) {
+	opts, err := loadConfig()
+	if err != nil {
+		return
+	}
+	b, err := InstantiateBackend(opts)
+	if err != nil {
+		return
+	}
+	v, err := resolveVersion(b, ref)
+	if err != nil {
+		return
+	}
+	argv, err := recordedCommand(v.meta)
+	if err != nil {
+		return
+	}
+	files, err := b.ListFiles(v)
+	if err != nil {
+		return
+	}
+	if len(outputs) == 0 {
+		return "", AnError{"Expecting the outputs of the command to compare, given with --output"}
+	}
+	found := false
+	for _, f := range files {
+		if f.Path != RunStdout && f.Path != RunStderr && isOutput(f.Path, outputs) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return "", AnError{"None of the given outputs is in the snapshot of " + v.id()}
+	}
+
+	dir, err := ioutil.TempDir("", "vio-reproduce-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+	if err = runGit(".", "worktree", "add", "--detach", dir, v.revision); err != nil {
+		return
+	}
+	defer runGit(".", "worktree", "remove", "--force", dir)
+
+	var buf bytes.Buffer
+	original := map[string]bool{}
+	restored := map[string]bool{}
+	for _, f := range files {
+		if isOutput(f.Path, outputs) {
+			original[f.Path] = true
+			continue
+		}
+		restored[f.Path] = true
+		r, err := b.OpenFile(v, f.Path)
+		if err != nil {
+			return "", err
+		}
+		err = restoreFile(r, dir+"/"+f.Path, f.Mode)
+		r.Close()
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString("restored:  " + f.Path + "\n")
+	}
+
+	t := metadata{
+		"message":    "reproduction of " + v.id(),
+		"reproduces": v.id()}
+	exitCode, err := runCommand(dir, argv, t)
+	if err != nil {
+		return
+	}
+
+	// the snapshots path is relative to the project, not to the worktree
+	snapsPath, err := filepath.Abs(opts.Section("").Key("snapshots_path").String())
+	if err != nil {
+		return
+	}
+	opts.Section("").Key("snapshots_path").SetValue(snapsPath)
+	wb, err := instantiateBackendAt(opts, dir)
+	if err != nil {
+		return
+	}
+	if err = addExtracted(t, opts, dir); err != nil {
+		return
+	}
+	addProvenance(t, opts)
+	nv, err := wb.Commit(t)
+	if err != nil {
+		return
+	}
+	newFiles, err := wb.ListFiles(nv)
+	if err != nil {
+		return
+	}
+
+	reproduced := map[string]bool{}
+	for _, f := range newFiles {
+		if restored[f.Path] {
+			continue
+		}
+		reproduced[f.Path] = true
+		if !original[f.Path] && f.Path != RunStdout && f.Path != RunStderr {
+			buf.WriteString("new:       " + f.Path + "\n")
+		}
+	}
+	paths := []string{}
+	for p := range original {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	identical, differ := 0, 0
+	for _, p := range paths {
+		if !reproduced[p] {
+			buf.WriteString("missing:   " + p + "\n")
+			differ++
+			continue
+		}
+		same, err := sameContents(wb, v, nv, p)
+		if err != nil {
+			return "", err
+		}
+		if same {
+			buf.WriteString("identical: " + p + "\n")
+			identical++
+		} else {
+			buf.WriteString("differs:   " + p + "\n")
+			differ++
+		}
+	}
+
+	if _, ok := v.meta["run.exit_code"]; ok && v.meta.str("run.exit_code") != t.str("run.exit_code") {
+		recorded := v.meta.str("run.exit_code")
+		buf.WriteString(fmt.Sprintf("exit code %d, originally %s\n", exitCode, recorded))
+		differ++
+	}
+	buf.WriteString(fmt.Sprintf("%d outputs identical, %d differ; committed as %s\n", identical, differ, nv.id()))
+	if differ > 0 {
+		return buf.String(), AnError{"Not reproduced, see 'vio diff " + v.id() + " " + nv.id() + "'"}
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,71 @@
+package vio
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReproduce(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	assert.Nil(t, Init(".snapshots", "posix"))
+
+	// params.txt is an input, results.txt an output
+	assert.Nil(t, ioutil.WriteFile("params.txt", []byte("threads=8\n"), 0644))
+	exitCode, err := Run("deterministic", "{}",
+		[]string{"sh", "-c", "cat params.txt > results.txt; echo done"})
+	assert.Nil(t, err)
+	assert.Equal(t, exitCode, 0)
+
+	// without outputs, nothing would be compared
+	_, err = Reproduce("@", nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "--output")
+	_, err = Reproduce("@", []string{"missing.txt"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "None of the given outputs")
+
+	time.Sleep(time.Second)
+	report, err := Reproduce("@", []string{"results.txt"})
+	assert.Nil(t, err)
+	assert.Contains(t, report, "restored:  params.txt\n")
+	assert.Contains(t, report, "identical: results.txt\n")
+	assert.Contains(t, report, "identical: "+RunStdout+"\n")
+	assert.Contains(t, report, "3 outputs identical, 0 differ; committed as ")
+
+	b, err := load()
+	assert.Nil(t, err)
+	vs, err := b.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(vs), 2)
+	assert.Equal(t, vs[1].meta["reproduces"], vs[0].id())
+
+	// the worktree is removed afterwards
+	out, err := runCmd(path, "git worktree list")
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Count(out, "\n"), 1, out)
+
+	assert.False(t, strings.Contains(report, ".git"))
+
+	time.Sleep(time.Second)
+	assert.Nil(t, os.Remove("results.txt"))
+	assert.Nil(t, os.Remove(RunStdout))
+	assert.Nil(t, os.Remove(RunStderr))
+	assert.Nil(t, ioutil.WriteFile("random.txt", []byte("4\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile("unused.txt", []byte("0\n"), 0644))
+	assert.Nil(t, Commit("with a shell command", `{"cmd": "rm unused.txt; date +%N > random.txt; touch extra.txt"}`))
+	time.Sleep(time.Second)
+	report, err = Reproduce("@", []string{"random.txt", "unused.txt"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Not reproduced")
+	assert.Contains(t, report, "differs:   random.txt\n")
+	assert.Contains(t, report, "missing:   unused.txt\n")
+	assert.Contains(t, report, "new:       extra.txt\n")
+	assert.False(t, strings.Contains(report, RunStdout))
+}
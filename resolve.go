@@ -0,0 +1,128 @@
+package vio
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maximum number of candidates listed when a reference is ambiguous
+const maxCandidates = 10
+
+// '@' or 'latest', optionally followed by '~<n>'
+var relativeRef = regexp.MustCompile(`^(@|latest)(~(\d*))?$`)
+
+// resolves a reference given by the user to a version of the index. A
+// reference is one of:
+//
+//   - 'latest' or '@', the newest version, optionally followed by '~<n>' to
+//     refer to the n-th version before it, e.g. '@~2'.
+//   - the name of a tag.
+//   - a version ID ('rev#timestamp') or a unique prefix of one.
+//   - a git revision, e.g. 'HEAD', 'main~3' or a commit hash, which refers
+//     to the newest version of that commit.
+func resolveVersion(b Backend, ref string) (v *version, err error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, AnError{"Empty version reference"}
+	}
+
+	idx, err := annotatedVersions(b)
+	if err != nil {
+		return
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return idx[i].timestamp.Before(idx[j].timestamp) })
+
+	if m := relativeRef.FindStringSubmatch(ref); m != nil {
+		n := 0
+		if m[2] != "" {
+			// like git, '~' alone means '~1'
+			n = 1
+			if m[3] != "" {
+				if n, err = strconv.Atoi(m[3]); err != nil {
+					return
+				}
+			}
+		}
+		if n >= len(idx) {
+			return nil, AnError{fmt.Sprintf("Version '%s' out of range, there are %d versions", ref, len(idx))}
+		}
+		return &idx[len(idx)-1-n], nil
+	}
+
+	tags, err := b.GetTags()
+	if err != nil {
+		return
+	}
+	if id, ok := tags[ref]; ok {
+		for i, v := range idx {
+			if v.id() == id {
+				return &idx[i], nil
+			}
+		}
+		return nil, AnError{"Tag '" + ref + "' refers to version " + id + ", which is not in index"}
+	}
+
+	var candidates []version
+	for i, v := range idx {
+		id := v.id()
+		if id == ref {
+			return &idx[i], nil
+		}
+		if strings.HasPrefix(id, ref) {
+			candidates = append(candidates, v)
+		}
+	}
+	if len(candidates) == 1 {
+		return &candidates[0], nil
+	}
+	if len(candidates) > 1 {
+		return nil, ambiguousError(ref, candidates)
+	}
+
+	if strings.Contains(ref, "#") {
+		if _, err = parseVersion(ref, nil); err != nil {
+			return
+		}
+		return nil, AnError{"Version " + ref + " not in index"}
+	}
+
+	commit, ok := resolveGitRevision(ref)
+	if !ok {
+		return nil, AnError{"Unknown version '" + ref + "'"}
+	}
+	for i := len(idx) - 1; i >= 0; i-- {
+		// the index stores abbreviated hashes
+		if strings.HasPrefix(commit, idx[i].revision) {
+			return &idx[i], nil
+		}
+	}
+	return nil, AnError{"No versions of commit " + commit[:7] + " (" + ref + ") in index"}
+}
+
+// returns the full hash of the commit that a git revision refers to
+func resolveGitRevision(rev string) (commit string, ok bool) {
+	out, err := exec.Command("git", "rev-parse", "--verify", "--quiet", rev+"^{commit}").Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(out)), true
+}
+
+func ambiguousError(ref string, candidates []version) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Version '%s' is ambiguous, it matches %d versions:", ref, len(candidates))
+	// newest first, which are usually the interesting ones
+	for i := len(candidates) - 1; i >= 0 && i >= len(candidates)-maxCandidates; i-- {
+		v := candidates[i]
+		fmt.Fprintf(&buf, "\n  %s#%d %s", v.revision, v.timestamp.Unix(), v.meta.str("message"))
+	}
+	if len(candidates) > maxCandidates {
+		fmt.Fprintf(&buf, "\n  ...")
+	}
+	return AnError{buf.String()}
+}
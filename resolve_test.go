@@ -0,0 +1,101 @@
+package vio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, err := parseVersion("ca82a6d#1448281434", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, v.revision, "ca82a6d")
+	assert.Equal(t, v.timestamp.Unix(), int64(1448281434))
+
+	for _, s := range []string{"ca82a6d#yesterday", "#1448281434", "a#1#2", ""} {
+		_, err := parseVersion(s, nil)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestParseIndexMalformed(t *testing.T) {
+	_, err := parseIndexLine("ca82a6d#yesterday,{}")
+	assert.NotNil(t, err)
+	_, err = parseIndexLine("ca82a6d,{}")
+	assert.NotNil(t, err)
+
+	// malformed lines are skipped
+	idx, err := parseIndex([]byte("ca82a6d#yesterday,{}\nca82a6d#1448281434,{}\nca82a6d,{}\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, len(idx), 1)
+	assert.Equal(t, idx[0].id(), "ca82a6d#1448281434")
+}
+
+func TestResolveVersion(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	err = Init(".snapshots", "posix")
+	assert.Nil(t, err)
+
+	first, err := GetCurrentCommitId(path)
+	assert.Nil(t, err)
+	_, err = runCmd(path, "git commit --allow-empty -m second")
+	assert.Nil(t, err)
+	second, err := GetCurrentCommitId(path)
+	assert.Nil(t, err)
+
+	ids := []string{
+		first + "#1448281434",
+		first + "#1448304512",
+		second + "#1448390000",
+		second + "#1448390100",
+	}
+	for _, id := range ids {
+		v := NewVersionWithMeta(id, metadata{"message": "msg " + id})
+		assert.Nil(t, addVersionToIndex(v, ".snapshots/index"))
+	}
+
+	b, err := load()
+	assert.Nil(t, err)
+
+	resolved := func(ref string) string {
+		v, err := resolveVersion(b, ref)
+		assert.Nil(t, err, ref)
+		if err != nil {
+			return ""
+		}
+		assert.Equal(t, v.meta["message"], "msg "+fmt.Sprintf("%s#%d", v.revision, v.timestamp.Unix()))
+		return fmt.Sprintf("%s#%d", v.revision, v.timestamp.Unix())
+	}
+
+	assert.Equal(t, resolved(ids[1]), ids[1])
+	assert.Equal(t, resolved(first+"#14482"), ids[0])
+	assert.Equal(t, resolved("latest"), ids[3])
+	assert.Equal(t, resolved("@"), ids[3])
+	assert.Equal(t, resolved("@~"), ids[2])
+	assert.Equal(t, resolved("@~3"), ids[0])
+	assert.Equal(t, resolved("latest~2"), ids[1])
+	assert.Equal(t, resolved("HEAD"), ids[3])
+	assert.Equal(t, resolved("HEAD~1"), ids[1])
+
+	_, err = resolveVersion(b, "@~4")
+	assert.NotNil(t, err)
+
+	_, err = resolveVersion(b, first)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "ambiguous"))
+	assert.True(t, strings.Contains(err.Error(), ids[0]))
+	assert.True(t, strings.Contains(err.Error(), ids[1]))
+
+	_, err = resolveVersion(b, first+"#yesterday")
+	assert.NotNil(t, err)
+	_, err = resolveVersion(b, first+"#1")
+	assert.NotNil(t, err)
+	_, err = resolveVersion(b, "no-such-ref")
+	assert.NotNil(t, err)
+}
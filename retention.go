@@ -0,0 +1,223 @@
+package vio
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// rules that decide which versions are removed by Prune. They are read from
+// the '[retention]' section of the configuration:
+//
+//	[retention]
+//	keep_last = 3          # latest 3 versions of each git commit
+//	keep_daily = 7         # latest version of each of the last 7 days
+//	keep_weekly = 4        # ... of each of the last 4 weeks
+//	keep_monthly = 12      # ... of each of the last 12 months
+//	drop_unreachable = yes # versions of commits that no branch contains
+//
+// Like restic's 'forget', days, weeks and months without versions don't
+// count. A version is kept if any rule keeps it. Versions whose metadata
+// has 'keep=true', and versions that are tagged, are always kept.
+type retentionPolicy struct {
+	keepLast        int
+	keepDaily       int
+	keepWeekly      int
+	keepMonthly     int
+	dropUnreachable bool
+}
+
+func loadRetentionPolicy(opts *ini.File) (p retentionPolicy, err error) {
+	s := opts.Section("retention")
+	for key, n := range map[string]*int{
+		"keep_last":    &p.keepLast,
+		"keep_daily":   &p.keepDaily,
+		"keep_weekly":  &p.keepWeekly,
+		"keep_monthly": &p.keepMonthly} {
+		if !s.HasKey(key) {
+			continue
+		}
+		if *n, err = s.Key(key).Int(); err != nil || *n < 0 {
+			return p, AnError{"Expecting a non-negative integer for '" + key + "' in [retention]"}
+		}
+	}
+	if s.HasKey("drop_unreachable") {
+		if p.dropUnreachable, err = s.Key("drop_unreachable").Bool(); err != nil {
+			return p, AnError{"Expecting a boolean for 'drop_unreachable' in [retention]"}
+		}
+	}
+	if p == (retentionPolicy{}) {
+		return p, AnError{"No retention rules in [retention] section of configuration"}
+	}
+	return
+}
+
+// whether the policy only drops versions and never decides which ones to
+// keep, in which case versions that aren't dropped are kept
+func (p retentionPolicy) onlyDrops() bool {
+	return p.keepLast == 0 && p.keepDaily == 0 && p.keepWeekly == 0 && p.keepMonthly == 0
+}
+
+// a rule that keeps the latest version of each of the n most recent
+// periods, where the period of a version is given by bucket
+type bucketRule struct {
+	name   string
+	n      int
+	bucket func(v version) string
+}
+
+// decides which versions to keep. Returns, for each version ID, the reasons
+// to keep it; versions that aren't in the returned map are to be removed.
+// reachable tells whether the commit of a version is in any branch.
+func (p retentionPolicy) apply(versions []version, tags map[string][]string,
+	reachable func(revision string) bool) (keep map[string][]string) {
+
+	// newest first, so that rules keep the latest version of each period
+	sorted := append([]version{}, versions...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].timestamp.After(sorted[j].timestamp) })
+
+	keep = map[string][]string{}
+	candidates := []version{}
+	for _, v := range sorted {
+		switch {
+		case v.meta.str("keep") == "true":
+			keep[v.id()] = append(keep[v.id()], "keep=true")
+		case len(tags[v.id()]) > 0:
+			keep[v.id()] = append(keep[v.id()], "tagged")
+		case p.dropUnreachable && !reachable(v.revision):
+			// dropped regardless of the other rules
+		case p.onlyDrops():
+			keep[v.id()] = append(keep[v.id()], "reachable")
+		default:
+			candidates = append(candidates, v)
+		}
+	}
+
+	perRevision := map[string]int{}
+	for _, v := range candidates {
+		if perRevision[v.revision] < p.keepLast {
+			perRevision[v.revision]++
+			keep[v.id()] = append(keep[v.id()], "last")
+		}
+	}
+
+	rules := []bucketRule{
+		{"daily", p.keepDaily, func(v version) string { return v.timestamp.Format("2006-01-02") }},
+		{"weekly", p.keepWeekly, func(v version) string {
+			year, week := v.timestamp.ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}},
+		{"monthly", p.keepMonthly, func(v version) string { return v.timestamp.Format("2006-01") }}}
+	for _, r := range rules {
+		last, count := "", 0
+		for _, v := range candidates {
+			if count == r.n {
+				break
+			}
+			if b := r.bucket(v); b != last {
+				last = b
+				count++
+				keep[v.id()] = append(keep[v.id()], r.name)
+			}
+		}
+	}
+	return
+}
+
+// returns a function that tells whether a (possibly abbreviated) commit is
+// in any of the given commits
+func containedIn(commits []string) func(revision string) bool {
+	sorted := append([]string{}, commits...)
+	sort.Strings(sorted)
+	return func(revision string) bool {
+		i := sort.SearchStrings(sorted, revision)
+		return i < len(sorted) && strings.HasPrefix(sorted[i], revision)
+	}
+}
+
+// removes the versions that the retention rules in the configuration don't
+// keep, and then the data that only they referred to. With dryRun, only
+// reports what would be removed.
+func Prune(dryRun bool) (report string, err error) {
+	opts, err := loadConfig()
+	if err != nil {
+		return
+	}
+	policy, err := loadRetentionPolicy(opts)
+	if err != nil {
+		return
+	}
+	b, err := InstantiateBackend(opts)
+	if err != nil {
+		return
+	}
+
+	versions, err := annotatedVersions(b)
+	if err != nil {
+		return
+	}
+	tags, err := b.GetTags()
+	if err != nil {
+		return
+	}
+	reachable := func(string) bool { return true }
+	if policy.dropUnreachable {
+		commits, err := GetReachableCommits(opts.Section("").Key("repo_path").MustString("."))
+		if err != nil {
+			return "", err
+		}
+		reachable = containedIn(commits)
+	}
+
+	keep := policy.apply(versions, tagsByVersion(tags), reachable)
+
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].timestamp.Before(versions[j].timestamp) })
+	var buf bytes.Buffer
+	remove := []*version{}
+	for i, v := range versions {
+		if reasons, ok := keep[v.id()]; ok {
+			fmt.Fprintf(&buf, "keep   %s (%s) %s\n", v.id(), strings.Join(reasons, ", "), v.meta.str("message"))
+		} else {
+			fmt.Fprintf(&buf, "remove %s %s\n", v.id(), v.meta.str("message"))
+			remove = append(remove, &versions[i])
+		}
+	}
+	if dryRun {
+		fmt.Fprintf(&buf, "%d versions kept, %d would be removed\n", len(versions)-len(remove), len(remove))
+	} else {
+		fmt.Fprintf(&buf, "%d versions kept, %d removed\n", len(versions)-len(remove), len(remove))
+	}
+	if len(remove) == 0 {
+		return buf.String(), nil
+	}
+
+	// collecting the removed versions collects whatever 'vio gc' would
+	// collect before, such as the data of versions removed with 'vio rm'
+	earlier, _, err := b.GC(true)
+	if err != nil {
+		return
+	}
+	verb := "also collected"
+	if dryRun {
+		verb = "would also collect"
+	}
+	for _, r := range earlier {
+		fmt.Fprintf(&buf, "%s %s\n", verb, r)
+	}
+	if dryRun {
+		return buf.String(), nil
+	}
+
+	if err = b.Remove(remove); err != nil {
+		return
+	}
+	_, freed, err := b.GC(false)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(&buf, "%d bytes freed\n", freed)
+	return buf.String(), nil
+}
@@ -0,0 +1,117 @@
+package vio
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/ini.v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadRetentionPolicy(t *testing.T) {
+	opts, err := ini.Load([]byte("[retention]\nkeep_last = 3\ndrop_unreachable = yes\n"))
+	assert.Nil(t, err)
+	p, err := loadRetentionPolicy(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, p, retentionPolicy{keepLast: 3, dropUnreachable: true})
+
+	for _, conf := range []string{"", "[retention]\n", "[retention]\nkeep_last = many\n",
+		"[retention]\nkeep_daily = -1\n", "[retention]\ndrop_unreachable = maybe\n"} {
+		opts, err := ini.Load([]byte(conf))
+		assert.Nil(t, err)
+		_, err = loadRetentionPolicy(opts)
+		assert.NotNil(t, err, conf)
+	}
+}
+
+func TestRetentionPolicyApply(t *testing.T) {
+	at := func(rev string, date string, meta metadata) version {
+		ts, err := time.Parse("2006-01-02 15:04", date)
+		assert.Nil(t, err)
+		return version{revision: rev, timestamp: ts, meta: meta}
+	}
+	v1 := at("aaaaaaa", "2020-01-01 10:00", nil)
+	v2 := at("aaaaaaa", "2020-01-01 11:00", nil)
+	v3 := at("bbbbbbb", "2020-01-02 10:00", nil)
+	v4 := at("aaaaaaa", "2020-01-20 10:00", nil)
+	v5 := at("bbbbbbb", "2020-02-15 10:00", metadata{"keep": "true"})
+	v6 := at("ccccccc", "2020-03-01 10:00", nil)
+	versions := []version{v1, v2, v3, v4, v5, v6}
+
+	p := retentionPolicy{keepLast: 1, keepDaily: 2, keepWeekly: 1, keepMonthly: 2, dropUnreachable: true}
+	reachable := containedIn([]string{"aaaaaaa0123", "bbbbbbb4567"})
+	keep := p.apply(versions, map[string][]string{v1.id(): {"baseline"}}, reachable)
+	assert.Equal(t, keep, map[string][]string{
+		v1.id(): {"tagged"},
+		v3.id(): {"last", "daily"},
+		v4.id(): {"last", "daily", "weekly", "monthly"},
+		v5.id(): {"keep=true"}})
+
+	// without rules that keep versions, all reachable versions are kept
+	keep = retentionPolicy{dropUnreachable: true}.apply(versions, nil, reachable)
+	assert.Equal(t, len(keep), 5)
+	_, ok := keep[v6.id()]
+	assert.False(t, ok)
+
+	assert.True(t, reachable("aaaaaaa"))
+	assert.False(t, reachable("abababa"))
+	assert.False(t, reachable("ccccccc"))
+}
+
+func TestPrune(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	assert.Nil(t, Init(".snapshots", "posix"))
+
+	for i, msg := range []string{"scratch", "first", "second", "third"} {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		assert.Nil(t, ioutil.WriteFile("results.txt", []byte(msg), 0644))
+		assert.Nil(t, Commit(msg, "{}"))
+	}
+	assert.Nil(t, Annotate("@~2", map[string]string{"keep": "true"}, nil))
+	assert.Nil(t, Remove([]string{"@~3"}))
+
+	_, err = Prune(true)
+	assert.NotNil(t, err)
+
+	f, err := os.OpenFile(".vioconfig", os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteString("\n[retention]\nkeep_last = 1\n")
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	report, err := Prune(true)
+	assert.Nil(t, err)
+	lines := strings.Split(report, "\n")
+	assert.True(t, strings.HasPrefix(lines[0], "keep   "))
+	assert.True(t, strings.HasSuffix(lines[0], " (keep=true) first"))
+	assert.True(t, strings.HasPrefix(lines[1], "remove "))
+	assert.True(t, strings.HasSuffix(lines[2], " (last) third"))
+	assert.Equal(t, lines[3], "2 versions kept, 1 would be removed")
+
+	// the data of versions removed earlier is collected as well
+	assert.True(t, strings.HasPrefix(lines[4], "would also collect .snapshots/"), lines[4])
+	assert.Equal(t, len(lines), 6)
+
+	logstr, err := Log()
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Count(logstr, "\n"), 3)
+
+	report, err = Prune(false)
+	assert.Nil(t, err)
+	assert.Contains(t, report, "2 versions kept, 1 removed\n")
+	assert.Contains(t, report, "also collected .snapshots/")
+	assert.Contains(t, report, " bytes freed\n")
+
+	logstr, err = Log()
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Count(logstr, "\n"), 2)
+	assert.False(t, strings.Contains(logstr, "second"))
+}
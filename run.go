@@ -0,0 +1,139 @@
+package vio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// files where the standard output and error of a command executed by Run
+// are captured. They are part of the snapshot that Run commits.
+const RunStdout = "vio-run.stdout"
+const RunStderr = "vio-run.stderr"
+
+// executes a command in the current directory, capturing its output, and
+// commits the unversioned files afterwards. Besides the given message and
+// metadata and provenance, the version records how the command was
+// executed in keys prefixed with 'run.'. Failed executions are committed as
+// well, with 'run.failed' set to true, and so are executions that modify
+// versioned files, with 'run.dirty' set to true and the modified files
+// listed in 'run.dirty_paths'. Returns the exit code of the command.
+func Run(message string, meta string, argv []string) (exitCode int, err error) {
+	if len(argv) == 0 {
+		return 0, AnError{"Empty command"}
+	}
+
+	t, err := parseMetadata([]byte(meta))
+	if err != nil {
+		return 0, AnError{"Error while unmarshaling JSON: " + err.Error()}
+	}
+	opts, err := loadConfig()
+	if err != nil {
+		return
+	}
+	b, err := InstantiateBackend(opts)
+	if err != nil {
+		return
+	}
+
+	// check before executing, otherwise the results couldn't be committed
+	if err = checkRepo(b, "."); err != nil {
+		return
+	}
+	if _, err = extractionRules(opts); err != nil {
+		return
+	}
+
+	t["message"] = message
+	if exitCode, err = runCommand(".", argv, t); err != nil {
+		return
+	}
+	modified, err := GetModifiedFiles(".")
+	if err != nil {
+		return
+	}
+	if len(modified) > 0 {
+		fmt.Fprintf(os.Stderr, "warning: the command modified versioned files: %s\n", strings.Join(modified, ", "))
+		t["run.dirty"] = true
+		t["run.dirty_paths"] = modified
+	}
+
+	if err = addExtracted(t, opts, "."); err != nil {
+		return
+	}
+	addProvenance(t, opts)
+	_, err = b.Commit(t)
+
+	return
+}
+
+// executes a command in the given folder, capturing its output there, and
+// records how it was executed in the 'run.' keys of the given metadata
+func runCommand(dir string, argv []string, t metadata) (exitCode int, err error) {
+	stdout, err := os.Create(filepath.Join(dir, RunStdout))
+	if err != nil {
+		return
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(filepath.Join(dir, RunStderr))
+	if err != nil {
+		return
+	}
+	defer stderr.Close()
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = io.MultiWriter(os.Stdout, stdout)
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+
+	// an interrupt is meant for the command; vio has to survive it in order
+	// to commit whatever the command left behind
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	start := time.Now()
+	runErr := cmd.Run()
+	end := time.Now()
+
+	t["run.argv"] = argv
+	t["run.start"] = start.Format(time.RFC3339Nano)
+	t["run.end"] = end.Format(time.RFC3339Nano)
+	t["run.wall_time"] = seconds(end.Sub(start))
+
+	exitCode = -1
+	if state := cmd.ProcessState; state != nil {
+		exitCode = state.ExitCode()
+		t["run.status"] = state.String()
+		t["run.user_time"] = seconds(state.UserTime())
+		t["run.system_time"] = seconds(state.SystemTime())
+		t["run.cpu_time"] = seconds(state.UserTime() + state.SystemTime())
+		if rss, ok := maxRSS(state); ok {
+			t["run.max_rss_kb"] = json.Number(strconv.FormatInt(rss, 10))
+		}
+	} else {
+		// the command couldn't be started
+		t["run.status"] = runErr.Error()
+	}
+	t["run.exit_code"] = json.Number(strconv.Itoa(exitCode))
+	t["run.failed"] = runErr != nil
+
+	if err = stdout.Sync(); err != nil {
+		return
+	}
+	err = stderr.Sync()
+	return
+}
+
+// formats a duration as a number of seconds, in milliseconds precision
+func seconds(d time.Duration) json.Number {
+	return json.Number(fmt.Sprintf("%.3f", d.Seconds()))
+}
@@ -0,0 +1,140 @@
+package vio
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdRun(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	err = Init(".snapshots", "posix")
+	assert.Nil(t, err)
+
+	exitCode, err := Run("it works", `{"foo": "bar"}`,
+		[]string{"sh", "-c", "echo results > results.txt; echo out; echo err >&2"})
+	assert.Nil(t, err)
+	assert.Equal(t, exitCode, 0)
+
+	b, err := load()
+	assert.Nil(t, err)
+	vs, err := b.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(vs), 1)
+
+	meta := vs[0].meta
+	assert.Equal(t, meta["message"], "it works")
+	assert.Equal(t, meta["foo"], "bar")
+	assert.Equal(t, meta["run.exit_code"], json.Number("0"))
+	assert.Equal(t, meta["run.failed"], false)
+	assert.Equal(t, meta["run.argv"], []interface{}{"sh", "-c", "echo results > results.txt; echo out; echo err >&2"})
+
+	start, err := time.Parse(time.RFC3339Nano, meta.str("run.start"))
+	assert.Nil(t, err)
+	end, err := time.Parse(time.RFC3339Nano, meta.str("run.end"))
+	assert.Nil(t, err)
+	assert.False(t, end.Before(start))
+
+	wall, err := strconv.ParseFloat(meta.str("run.wall_time"), 64)
+	assert.Nil(t, err)
+	assert.True(t, wall >= 0)
+	_, err = strconv.ParseFloat(meta.str("run.cpu_time"), 64)
+	assert.Nil(t, err)
+	rss, err := strconv.ParseInt(meta.str("run.max_rss_kb"), 10, 64)
+	assert.Nil(t, err)
+	assert.True(t, rss > 0)
+
+	contents, err := readFile(b, &vs[0], RunStdout)
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "out\n")
+	contents, err = readFile(b, &vs[0], RunStderr)
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "err\n")
+	contents, err = readFile(b, &vs[0], "results.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "results\n")
+}
+
+func TestCmdRunFailed(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	err = Init(".snapshots", "posix")
+	assert.Nil(t, err)
+
+	exitCode, err := Run("crash", "{}",
+		[]string{"sh", "-c", "echo partial > results.txt; exit 3"})
+	assert.Nil(t, err)
+	assert.Equal(t, exitCode, 3)
+
+	time.Sleep(time.Second)
+
+	// commands that can't be executed are recorded too
+	exitCode, err = Run("missing", "{}", []string{"./does-not-exist"})
+	assert.Nil(t, err)
+	assert.Equal(t, exitCode, -1)
+
+	b, err := load()
+	assert.Nil(t, err)
+	vs, err := b.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(vs), 2)
+
+	assert.Equal(t, vs[0].meta["run.exit_code"], json.Number("3"))
+	assert.Equal(t, vs[0].meta["run.failed"], true)
+	contents, err := readFile(b, &vs[0], "results.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "partial\n")
+
+	assert.Equal(t, vs[1].meta["run.exit_code"], json.Number("-1"))
+	assert.Equal(t, vs[1].meta["run.failed"], true)
+}
+
+func TestCmdRunDirty(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	err = Init(".snapshots", "posix")
+	assert.Nil(t, err)
+
+	// a command that modifies a versioned file is still recorded
+	exitCode, err := Run("dirty", "{}",
+		[]string{"sh", "-c", "echo results > results.txt; echo changed >> README"})
+	assert.Nil(t, err)
+	assert.Equal(t, exitCode, 0)
+
+	b, err := load()
+	assert.Nil(t, err)
+	vs, err := b.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(vs), 1)
+	assert.Equal(t, vs[0].meta["run.dirty"], true)
+	assert.Equal(t, vs[0].meta["run.dirty_paths"], []interface{}{"README"})
+	contents, err := readFile(b, &vs[0], "results.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, string(contents), "results\n")
+
+	// but uncommitted changes from before aren't accepted
+	time.Sleep(time.Second)
+	_, err = Run("again", "{}", []string{"sh", "-c", "echo again > again.txt"})
+	assert.NotNil(t, err)
+	_, err = os.Stat("again.txt")
+	assert.True(t, os.IsNotExist(err))
+	assert.NotNil(t, Commit("dirty", `{"run.dirty_paths": []}`))
+}
+
+func TestCmdRunUninitialized(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+
+	_, err = Run("msg", "{}", []string{"true"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package vio
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// returns the peak resident set size of a process, in kilobytes
+func maxRSS(state *os.ProcessState) (int64, bool) {
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0, false
+	}
+	if runtime.GOOS == "darwin" {
+		// reported in bytes instead of kilobytes
+		return int64(usage.Maxrss) / 1024, true
+	}
+	return int64(usage.Maxrss), true
+}
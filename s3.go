@@ -0,0 +1,344 @@
+package vio
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SHA-256 of an empty payload
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// number of times a conditional update is retried before giving up
+const maxUpdateRetries = 20
+
+// a minimal client for S3-compatible object stores. Requests are signed
+// with AWS Signature Version 4 and buckets are addressed path-style
+// ('<endpoint>/<bucket>/<key>'), which is what most S3-compatible servers
+// expect.
+type s3Client struct {
+	endpoint     string
+	bucket       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// an error response from the server
+type s3Error struct {
+	StatusCode int
+	Msg        string
+}
+
+func (e s3Error) Error() string {
+	return fmt.Sprintf("S3 request failed with status %d: %s", e.StatusCode, e.Msg)
+}
+
+func isS3Status(err error, status int) bool {
+	e, ok := err.(s3Error)
+	return ok && e.StatusCode == status
+}
+
+// encodes a string as specified by SigV4, which is stricter than what
+// net/url does
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// adds the SigV4 authorization headers to a request. All the headers of the
+// request, plus Host, are signed.
+func (c *s3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		trimmed := []string{}
+		for _, v := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := []string{}
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+// sends a signed request for a key of the bucket. An empty key addresses the
+// bucket itself. Responses other than 2xx are returned as an s3Error.
+func (c *s3Client) do(method string, key string, query url.Values, headers map[string]string,
+	body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+
+	u, err := url.Parse(c.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	path := "/" + c.bucket
+	if key != "" {
+		path += "/" + key
+	}
+	base := strings.TrimSuffix(u.Path, "/")
+	u.Path = base + path
+	u.RawPath = awsURIEncode(base+path, false)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	c.sign(req, payloadHash, time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, s3Error{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// returns the contents of an object and its ETag
+func (c *s3Client) get(key string) (data []byte, etag string, err error) {
+	resp, err := c.do("GET", key, nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	return data, resp.Header.Get("ETag"), err
+}
+
+func (c *s3Client) getStream(key string) (io.ReadCloser, error) {
+	resp, err := c.do("GET", key, nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *s3Client) exists(key string) (bool, error) {
+	resp, err := c.do("HEAD", key, nil, nil, nil, 0, emptyPayloadHash)
+	if isS3Status(err, http.StatusNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// returns when an object was last modified, or false if it doesn't exist
+func (c *s3Client) lastModified(key string) (modified time.Time, exists bool, err error) {
+	resp, err := c.do("HEAD", key, nil, nil, nil, 0, emptyPayloadHash)
+	if isS3Status(err, http.StatusNotFound) {
+		return modified, false, nil
+	}
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	modified, err = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return modified, true, err
+}
+
+// sets the modification time of an object to now by copying it onto
+// itself. Returns false if the object doesn't exist.
+func (c *s3Client) touch(key string) (bool, error) {
+	headers := map[string]string{
+		"x-amz-copy-source":        awsURIEncode("/"+c.bucket+"/"+key, false),
+		"x-amz-metadata-directive": "REPLACE"}
+	resp, err := c.do("PUT", key, nil, headers, nil, 0, emptyPayloadHash)
+	if isS3Status(err, http.StatusNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// uploads an object whose SHA-256 digest is already known
+func (c *s3Client) put(key string, body io.Reader, size int64, digest string,
+	headers map[string]string) error {
+
+	resp, err := c.do("PUT", key, nil, headers, body, size, digest)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *s3Client) putBytes(key string, data []byte, headers map[string]string) error {
+	return c.put(key, bytes.NewReader(data), int64(len(data)), sha256Hex(data), headers)
+}
+
+// applies f to the contents of an object and writes the result back, but
+// only if nobody modified the object in the meantime. Otherwise, the update
+// is retried on the new contents. This replaces a lock, which S3 lacks.
+func (c *s3Client) update(key string, f func(data []byte) ([]byte, error)) error {
+	for i := 0; ; i++ {
+		data, etag, err := c.get(key)
+		if err != nil {
+			return err
+		}
+		updated, err := f(data)
+		if err != nil {
+			return err
+		}
+		err = c.putBytes(key, updated, map[string]string{"If-Match": etag})
+		if i < maxUpdateRetries &&
+			(isS3Status(err, http.StatusPreconditionFailed) || isS3Status(err, http.StatusConflict)) {
+			// back off for a random time so that writers get out of sync
+			time.Sleep(time.Duration(rand.Intn(50*(i+1))) * time.Millisecond)
+			continue
+		}
+		return err
+	}
+}
+
+// like update, but creates the object empty first if it doesn't exist
+func (c *s3Client) updateOrCreate(key string, f func(data []byte) ([]byte, error)) error {
+	err := c.putBytes(key, []byte(""), map[string]string{"If-None-Match": "*"})
+	if err != nil && !isS3Status(err, http.StatusPreconditionFailed) {
+		return err
+	}
+	return c.update(key, f)
+}
+
+func (c *s3Client) delete(key string) error {
+	resp, err := c.do("DELETE", key, nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// an object, as listed by the server
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type listBucketResult struct {
+	Contents              []s3Object `xml:"Contents"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+// lists the objects whose key starts with a prefix, following as many
+// continuation tokens as needed
+func (c *s3Client) list(prefix string) (objects []s3Object, err error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := c.do("GET", "", query, nil, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
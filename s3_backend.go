@@ -0,0 +1,644 @@
+package vio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// S3Backend stores snapshots in a bucket of an S3-compatible object store,
+// content-addressed like CasBackend but without splitting objects into
+// folders by the first characters of their digest: files are stored once
+// under '<prefix>/objects/<sha256>' and each snapshot is a manifest stored in
+// '<prefix>/manifests/<revision>/<timestamp>'. The index is the object
+// '<prefix>/index', which is updated with conditional requests instead of
+// being locked.
+//
+// The following keys are read from the configuration: s3_endpoint,
+// s3_bucket, s3_prefix, s3_region, s3_access_key and s3_secret_key. The
+// region and credentials default to the AWS_REGION, AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+type S3Backend struct {
+	repoPath string
+	prefix   string
+	client   *s3Client
+}
+
+func NewS3Backend(o *ini.File) (b Backend, err error) {
+	if !o.Section("").HasKey("repo_path") {
+		return nil, AnError{"Expecting key 'repo_path' in configuration."}
+	}
+	if !o.Section("").HasKey("s3_bucket") {
+		return nil, AnError{"Expecting key 's3_bucket' in configuration."}
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	s := o.Section("")
+	return &S3Backend{
+		repoPath: s.Key("repo_path").String(),
+		prefix:   strings.Trim(s.Key("s3_prefix").String(), "/"),
+		client: &s3Client{
+			endpoint:     s.Key("s3_endpoint").MustString("https://s3.amazonaws.com"),
+			bucket:       s.Key("s3_bucket").String(),
+			region:       s.Key("s3_region").MustString(region),
+			accessKey:    s.Key("s3_access_key").MustString(os.Getenv("AWS_ACCESS_KEY_ID")),
+			secretKey:    s.Key("s3_secret_key").MustString(os.Getenv("AWS_SECRET_ACCESS_KEY")),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			client:       http.DefaultClient}}, nil
+}
+
+// objects modified more recently than this are never garbage-collected,
+// since they may belong to a commit that hasn't updated the index yet
+const s3GCGracePeriod = time.Hour
+
+// returns the key of an object, relative to the configured prefix
+func (b S3Backend) key(path string) string {
+	if b.prefix == "" {
+		return path
+	}
+	return b.prefix + "/" + path
+}
+
+func (b S3Backend) objectKey(digest string) string {
+	return b.key("objects/" + digest)
+}
+
+func (b S3Backend) manifestKey(v *version) string {
+	return b.key(fmt.Sprintf("manifests/%s/%d", v.revision, v.timestamp.Unix()))
+}
+
+// the sidecar object with the line of the index of a version
+func (b S3Backend) sidecarKey(v *version) string {
+	return b.key(fmt.Sprintf("metadata/%s/%d", v.revision, v.timestamp.Unix()))
+}
+
+func (b S3Backend) Init() (err error) {
+	err = b.client.putBytes(b.key("index"), emptyIndex(), map[string]string{"If-None-Match": "*"})
+	if isS3Status(err, http.StatusPreconditionFailed) {
+		return AnError{"Repository already initialized"}
+	}
+	return
+}
+
+func (b S3Backend) Open() error {
+	return nil
+}
+
+func (b S3Backend) IsInitialized() bool {
+	exists, err := b.client.exists(b.key("index"))
+	return err == nil && exists
+}
+
+func (b S3Backend) GetStatus() (Status, error) {
+	return stagingStatus(b.repoPath)
+}
+
+func (b S3Backend) Checkout(v *version) (err error) {
+	if err = checkRepo(b, b.repoPath); err != nil {
+		return
+	}
+
+	if err = checkInIndex(b, v); err != nil {
+		return
+	}
+
+	manifest, err := b.readManifest(v)
+	if err != nil {
+		return
+	}
+	for _, e := range manifest {
+		r, err := b.client.getStream(b.objectKey(e.Digest))
+		if err != nil {
+			return err
+		}
+		err = restoreFile(r, b.repoPath+"/"+e.Path, e.Mode)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return
+}
+
+func (b S3Backend) Commit(meta metadata) (v *version, err error) {
+	if err = checkCommit(b, b.repoPath, meta); err != nil {
+		return
+	}
+	versionedFiles, err := GetVersionedFiles(b.repoPath)
+	if err != nil {
+		return
+	}
+
+	id, err := GetCurrentCommitId(b.repoPath)
+	if err != nil {
+		return
+	}
+
+	v = NewVersionWithMeta(id, meta)
+
+	// fail early instead of after uploading everything; the check is
+	// repeated when updating the index
+	idx, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+	if ContainsVersion(idx, v) {
+		return nil, AnError{"Version " + fmt.Sprintf("%v", v) + " already in index."}
+	}
+
+	files, err := filesToCommit(b.repoPath, "", versionedFiles)
+	if err != nil {
+		return
+	}
+
+	if err = b.storeSnapshot(v, b.repoPath, files); err != nil {
+		return
+	}
+
+	err = clearStaged(b.repoPath)
+	return
+}
+
+// uploads the given files of a folder and the manifest that lists them,
+// and then adds the version to the index
+func (b S3Backend) storeSnapshot(v *version, root string, files []string) (err error) {
+	manifest := []manifestEntry{}
+	for _, file := range files {
+		info, err := os.Lstat(root + "/" + file)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		digest, size, err := b.upload(root + "/" + file)
+		if err != nil {
+			return err
+		}
+		manifest = append(manifest, manifestEntry{file, size, info.Mode(), digest})
+	}
+
+	contents, err := json.Marshal(manifest)
+	if err != nil {
+		return
+	}
+	if err = b.client.putBytes(b.manifestKey(v), contents, nil); err != nil {
+		return
+	}
+	if err = b.client.putBytes(b.sidecarKey(v), []byte(fmt.Sprintf("%v\n", v)), nil); err != nil {
+		return
+	}
+
+	err = b.client.update(b.key("index"), func(data []byte) ([]byte, error) {
+		idx, err := decodeIndex(data)
+		if err != nil {
+			return nil, err
+		}
+		if err = idx.add(v); err != nil {
+			return nil, err
+		}
+		return idx.encode()
+	})
+	if err != nil {
+		return
+	}
+	return b.updateRemoved(nil, v)
+}
+
+// reads the list of removed versions
+func (b S3Backend) removedVersions() (map[string]bool, error) {
+	contents, _, err := b.client.get(b.key("removed"))
+	if err != nil && !isS3Status(err, http.StatusNotFound) {
+		return nil, err
+	}
+	return parseRemoved(contents), nil
+}
+
+// updates the list of removed versions, which reindexing leaves out
+func (b S3Backend) updateRemoved(removed []*version, readded *version) error {
+	f := func(data []byte) ([]byte, error) {
+		return updateRemoved(data, removed, readded), nil
+	}
+	if len(removed) > 0 {
+		return b.client.updateOrCreate(b.key("removed"), f)
+	}
+	err := b.client.update(b.key("removed"), f)
+	if isS3Status(err, http.StatusNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (b S3Backend) Import(v *version, dir string) (err error) {
+	// checked again when updating the index
+	idx, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+	if ContainsVersion(idx, v) {
+		return AnError{"Version " + v.id() + " already in index."}
+	}
+
+	files, err := listDir(dir)
+	if err != nil {
+		return
+	}
+	return b.storeSnapshot(v, dir, files)
+}
+
+// uploads a file (or the target of a symlink) to the bucket, unless an
+// object with the same contents is already there. In that case, the object
+// is touched instead, so that a concurrent GC doesn't remove it because it
+// is old and no version referred to it yet.
+func (b S3Backend) upload(path string) (digest string, size int64, err error) {
+	src, closer, err := openSource(path)
+	if err != nil {
+		return
+	}
+	defer closer()
+
+	if digest, size, err = digestOf(src); err != nil {
+		return
+	}
+
+	exists, err := b.client.touch(b.objectKey(digest))
+	if err != nil || exists {
+		return
+	}
+
+	if _, err = src.Seek(0, 0); err != nil {
+		return
+	}
+	err = b.client.put(b.objectKey(digest), src, size, digest, nil)
+	return
+}
+
+func (b S3Backend) readManifest(v *version) (manifest []manifestEntry, err error) {
+	contents, _, err := b.client.get(b.manifestKey(v))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(contents, &manifest)
+	return
+}
+
+func (b S3Backend) GetVersions() (versions []version, err error) {
+	contents, _, err := b.client.get(b.key("index"))
+	if err != nil {
+		return
+	}
+	return parseIndex(contents)
+}
+
+func (b S3Backend) GetTags() (map[string]string, error) {
+	contents, _, err := b.client.get(b.key("tags"))
+	if isS3Status(err, http.StatusNotFound) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseTags(contents)
+}
+
+// modifies the tags object, creating it first if the repo has no tags yet
+func (b S3Backend) updateTags(f func(tags map[string]string) error) error {
+	return b.client.updateOrCreate(b.key("tags"), func(data []byte) ([]byte, error) {
+		tags, err := parseTags(data)
+		if err != nil {
+			return nil, err
+		}
+		if err = f(tags); err != nil {
+			return nil, err
+		}
+		return formatTags(tags), nil
+	})
+}
+
+func (b S3Backend) SetTag(name string, v *version) error {
+	return b.updateTags(func(tags map[string]string) error {
+		return addTag(tags, name, v)
+	})
+}
+
+func (b S3Backend) DeleteTag(name string) error {
+	return b.updateTags(func(tags map[string]string) error {
+		return removeTag(tags, name)
+	})
+}
+
+func (b S3Backend) GetAnnotations() ([]annotation, error) {
+	contents, _, err := b.client.get(b.key("annotations"))
+	if isS3Status(err, http.StatusNotFound) {
+		return []annotation{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseAnnotations(contents)
+}
+
+func (b S3Backend) Annotate(a annotation) error {
+	line, err := formatAnnotation(a)
+	if err != nil {
+		return err
+	}
+	return b.client.updateOrCreate(b.key("annotations"), func(data []byte) ([]byte, error) {
+		return append(data, line...), nil
+	})
+}
+
+func (b S3Backend) Remove(vs []*version) error {
+	err := b.client.update(b.key("index"), func(data []byte) ([]byte, error) {
+		return removeFromIndex(data, vs)
+	})
+	if err != nil {
+		return err
+	}
+	return b.updateRemoved(vs, nil)
+}
+
+// removes the manifests of versions removed from the index and the objects
+// that no remaining manifest refers to. The bucket can't be locked, so
+// objects younger than s3GCGracePeriod are left alone. Since commits touch
+// the objects they reuse, objects are checked again right before being
+// removed.
+func (b S3Backend) GC(dryRun bool) (removed []string, freed int64, err error) {
+	idx, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+	referenced := map[string]bool{}
+	for i := range idx {
+		manifest, err := b.readManifest(&idx[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		referenced[b.manifestKey(&idx[i])] = true
+		referenced[b.sidecarKey(&idx[i])] = true
+		for _, e := range manifest {
+			referenced[b.objectKey(e.Digest)] = true
+		}
+	}
+	removedVersions, err := b.removedVersions()
+	if err != nil {
+		return
+	}
+
+	manifests, err := b.client.list(b.key("manifests/"))
+	if err != nil {
+		return
+	}
+	for _, o := range manifests {
+		if referenced[o.Key] || collectable(o.Key, removedVersions) {
+			continue
+		}
+		v, _ := versionOfPath(o.Key)
+		manifest, err := b.readManifest(v)
+		if err != nil {
+			return nil, 0, err
+		}
+		referenced[o.Key] = true
+		referenced[b.sidecarKey(v)] = true
+		for _, e := range manifest {
+			referenced[b.objectKey(e.Digest)] = true
+		}
+	}
+	sidecars, err := b.client.list(b.key("metadata/"))
+	if err != nil {
+		return
+	}
+	objects, err := b.client.list(b.key("objects/"))
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-s3GCGracePeriod)
+	for _, o := range append(append(manifests, sidecars...), objects...) {
+		if referenced[o.Key] || o.LastModified.After(cutoff) {
+			continue
+		}
+		var modified time.Time
+		var exists bool
+		if modified, exists, err = b.client.lastModified(o.Key); err != nil {
+			return
+		}
+		if !exists || modified.After(cutoff) {
+			continue
+		}
+		removed = append(removed, o.Key)
+		freed += o.Size
+		if dryRun {
+			continue
+		}
+		if err = b.client.delete(o.Key); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (b S3Backend) Diff(v1 *version, v2 *version, obj string) (string, error) {
+	if err := checkInIndex(b, v1, v2); err != nil {
+		return "", err
+	}
+	return diffVersions(b, v1, v2, obj)
+}
+
+func (b S3Backend) ListFiles(v *version) (files []FileInfo, err error) {
+	manifest, err := b.readManifest(v)
+	if err != nil {
+		return
+	}
+	files = []FileInfo{}
+	for _, e := range manifest {
+		files = append(files, FileInfo{Path: e.Path, Size: e.Size, Mode: e.Mode})
+	}
+	return
+}
+
+func (b S3Backend) OpenFile(v *version, path string) (io.ReadCloser, error) {
+	manifest, err := b.readManifest(v)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range manifest {
+		if e.Path == path {
+			return b.client.getStream(b.objectKey(e.Digest))
+		}
+	}
+	return nil, AnError{"File " + path + " not in version " + v.revision}
+}
+
+// checks the index, and that the objects of every version match the
+// digests in its manifest, which requires downloading all of them
+func (b S3Backend) Fsck(repair bool) (problems []problem, err error) {
+	contents, _, err := b.client.get(b.key("index"))
+	if err != nil {
+		return
+	}
+	stored := func(v *version) (bool, error) {
+		return b.client.exists(b.manifestKey(v))
+	}
+	versions, _, problems, err := checkIndex(contents, stored)
+	if err != nil {
+		return
+	}
+	if repair && len(problems) > 0 {
+		if err = b.client.putBytes(b.key("index.bak"), contents, nil); err != nil {
+			return
+		}
+		err = b.client.update(b.key("index"), func(data []byte) ([]byte, error) {
+			_, fixed, _, err := checkIndex(data, stored)
+			return fixed, err
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	kept := map[string]bool{}
+	checked := map[string]string{}
+	open := func(digest string) (io.ReadCloser, error) {
+		return b.client.getStream(b.objectKey(digest))
+	}
+	for i := range versions {
+		v := &versions[i]
+		kept[b.manifestKey(v)] = true
+		manifest, err := b.readManifest(v)
+		if err != nil {
+			problems = append(problems, problem{msg: "version " + v.id() + ": " + err.Error()})
+			continue
+		}
+		problems = append(problems, verifyObjects(v, manifest, open, checked)...)
+	}
+
+	removed, err := b.removedVersions()
+	if err != nil {
+		return
+	}
+	manifests, err := b.client.list(b.key("manifests/"))
+	if err != nil {
+		return
+	}
+	for _, o := range manifests {
+		if !kept[o.Key] {
+			problems = append(problems, unindexedProblem("manifest", o.Key, removed))
+		}
+	}
+	return
+}
+
+// rebuilds the index from the stored manifests and their sidecar objects
+func (b S3Backend) Reindex(dryRun bool) (versions []version, err error) {
+	manifests, err := b.client.list(b.key("manifests/"))
+	if err != nil {
+		return
+	}
+	found := []version{}
+	for _, o := range manifests {
+		v, ok := versionOfPath(o.Key)
+		if !ok {
+			continue
+		}
+		contents, _, err := b.client.get(b.sidecarKey(v))
+		if err != nil && !isS3Status(err, http.StatusNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			v.meta = parseSidecar(contents, v)
+		}
+		found = append(found, *v)
+	}
+	removed, err := b.removedVersions()
+	if err != nil {
+		return
+	}
+	found = withoutRemoved(found, removed)
+
+	old, _, err := b.client.get(b.key("index"))
+	if err != nil && !isS3Status(err, http.StatusNotFound) {
+		return
+	}
+	contents, err := rebuildIndex(found, old)
+	if err != nil {
+		return
+	}
+	if versions, err = parseIndex(contents); err != nil || dryRun {
+		return
+	}
+	if err = b.client.putBytes(b.key("index.bak"), old, nil); err != nil {
+		return
+	}
+
+	// versions committed since the index was read are kept, but not those
+	// that were already in it, which are the ones reindexing checks
+	seen := map[string]bool{}
+	for _, v := range found {
+		seen[v.id()] = true
+	}
+	if idx, err := decodeIndex(old); err == nil {
+		for _, v := range idx.versions() {
+			seen[v.id()] = true
+		}
+	}
+	err = b.client.updateOrCreate(b.key("index"), func(data []byte) ([]byte, error) {
+		current := append([]version{}, found...)
+		if idx, err := decodeIndex(data); err == nil {
+			for _, v := range idx.versions() {
+				if !seen[v.id()] {
+					current = append(current, v)
+				}
+			}
+		}
+		contents, err = rebuildIndex(current, data)
+		return contents, err
+	})
+	if err != nil {
+		return
+	}
+	return parseIndex(contents)
+}
+
+// migrates the index to the current format. The backup is written before
+// the update, which is repeated if the index changed in the meantime.
+func (b S3Backend) UpgradeIndex() (from int, err error) {
+	contents, _, err := b.client.get(b.key("index"))
+	if err != nil {
+		return
+	}
+	idx, err := decodeIndex(contents)
+	if err != nil {
+		return
+	}
+	if from = idx.format; from == indexFormat {
+		return
+	}
+	if err = idx.upgrade(); err != nil {
+		return
+	}
+	if err = b.client.putBytes(b.key("index.bak"), contents, nil); err != nil {
+		return
+	}
+	err = b.client.update(b.key("index"), func(data []byte) ([]byte, error) {
+		idx, err := decodeIndex(data)
+		if err != nil {
+			return nil, err
+		}
+		if err = idx.upgrade(); err != nil {
+			return nil, err
+		}
+		return idx.encode()
+	})
+	return
+}
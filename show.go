@@ -0,0 +1,78 @@
+package vio
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// describes a version: its metadata, the git commit it belongs to and the
+// files it contains, along with their SHA-256 checksums. If stat is true,
+// only the number of files and their total size are shown instead of the
+// listing.
+func showVersion(b Backend, v *version, stat bool) (out string, err error) {
+	files, err := b.ListFiles(v)
+	if err != nil {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	annotations, err := annotationsOf(b, v)
+	if err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(formatFull(*v, gitCommitInfo(v.revision)...))
+	buf.WriteString("\n")
+
+	if len(annotations) > 0 {
+		buf.WriteString("Annotations:\n")
+		for _, a := range annotations {
+			buf.WriteString("    " + a.String() + "\n")
+		}
+		buf.WriteString("\n")
+	}
+
+	if stat {
+		var total int64
+		for _, f := range files {
+			total += f.Size
+		}
+		fmt.Fprintf(&buf, "%d files, %d bytes\n", len(files), total)
+		return buf.String(), nil
+	}
+
+	for _, f := range files {
+		r, err := b.OpenFile(v, f.Path)
+		if err != nil {
+			return "", err
+		}
+		digest, _, err := digestOf(r)
+		r.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "%s %10d %s %s\n", f.Mode, f.Size, digest, f.Path)
+	}
+	return buf.String(), nil
+}
+
+// returns header lines with the subject and author of a git commit, or
+// nothing if the commit is not in the repo
+func gitCommitInfo(revision string) []string {
+	out, err := exec.Command("git", "log", "-1", "--format=%H%n%an <%ae>%n%s",
+		revision+"^{commit}", "--").Output()
+	if err != nil {
+		return nil
+	}
+	lines := strings.SplitN(strings.TrimRight(string(out), "\n"), "\n", 3)
+	if len(lines) != 3 {
+		return nil
+	}
+	return []string{
+		"Commit: " + lines[0] + " " + lines[2],
+		"Author: " + lines[1]}
+}
@@ -0,0 +1,65 @@
+package vio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testShow(t *testing.T, backend string) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	err = Init(".snapshots", backend)
+	assert.Nil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile("results.txt", []byte("42\n"), 0644))
+	assert.Nil(t, os.MkdirAll("out", 0755))
+	assert.Nil(t, ioutil.WriteFile("out/run.sh", []byte("#!/bin/sh\n"), 0755))
+	err = Commit("my results", `{"threads": "64"}`)
+	assert.Nil(t, err)
+
+	b, err := load()
+	assert.Nil(t, err)
+	vs, err := b.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(vs), 1)
+	v := fmt.Sprintf("%s#%d", vs[0].revision, vs[0].timestamp.Unix())
+
+	showstr, err := Show(v, false)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(showstr, "version "+v+"\nCommit: "))
+	assert.Contains(t, showstr, " yeah\n")
+	assert.Contains(t, showstr, "\n    my results\n")
+	assert.Contains(t, showstr, "\n    threads: 64\n")
+
+	digest := sha256.Sum256([]byte("42\n"))
+	assert.Contains(t, showstr,
+		fmt.Sprintf("-rw-r--r--          3 %s results.txt\n", hex.EncodeToString(digest[:])))
+	assert.Contains(t, showstr, "-rwxr-xr-x         10 ")
+	assert.Contains(t, showstr, " out/run.sh\n")
+
+	showstr, err = Show(v, true)
+	assert.Nil(t, err)
+	// .vioconfig is part of the snapshot too
+	info, err := os.Stat(".vioconfig")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(showstr, fmt.Sprintf("\n\n3 files, %d bytes\n", 13+info.Size())))
+
+	_, err = Show(vs[0].revision+"#0", false)
+	assert.NotNil(t, err)
+}
+
+func TestShowPosix(t *testing.T) {
+	testShow(t, "posix")
+}
+
+func TestShowGit(t *testing.T) {
+	testShow(t, "git")
+}
@@ -0,0 +1,334 @@
+package vio
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// name of the file, inside the git directory of the repo, that lists the
+// staged files. Keeping it there means it's never part of a snapshot and
+// that each working copy has its own staging area.
+const stagingFile = "vio-staged"
+
+func stagingPath(repoPath string) (string, error) {
+	out, err := runCmd(repoPath, "git rev-parse --git-dir")
+	if err != nil {
+		return "", err
+	}
+	gitDir := strings.TrimSpace(out)
+	if !filepath.IsAbs(gitDir) {
+		gitDir = repoPath + "/" + gitDir
+	}
+	return gitDir + "/" + stagingFile, nil
+}
+
+// returns the staged files, relative to the repo
+func readStaged(repoPath string) (files []string, err error) {
+	path, err := stagingPath(repoPath)
+	if err != nil {
+		return
+	}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if line != "" {
+			files = append(files, line)
+		}
+	}
+	return
+}
+
+// replaces the staged files by the given ones
+func writeStaged(repoPath string, files []string) (err error) {
+	path, err := stagingPath(repoPath)
+	if err != nil {
+		return
+	}
+	if len(files) == 0 {
+		if err = os.Remove(path); os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	unique := map[string]bool{}
+	for _, f := range files {
+		unique[f] = true
+	}
+	sorted := []string{}
+	for f := range unique {
+		sorted = append(sorted, f)
+	}
+	sort.Strings(sorted)
+	return ioutil.WriteFile(path, []byte(strings.Join(sorted, "\n")+"\n"), 0644)
+}
+
+func clearStaged(repoPath string) error {
+	return writeStaged(repoPath, nil)
+}
+
+// returns Staged if there are staged files, Committed otherwise
+func stagingStatus(repoPath string) (Status, error) {
+	files, err := readStaged(repoPath)
+	if err != nil {
+		return Committed, err
+	}
+	if len(files) > 0 {
+		return Staged, nil
+	}
+	return Committed, nil
+}
+
+// returns the staged files, or nil if nothing is staged. Fails if a staged
+// file has been removed or is now versioned.
+func stagedFiles(repoPath string, snapsPath string, versionedFiles []string) (staged []string, err error) {
+	staged, err = readStaged(repoPath)
+	if err != nil || len(staged) == 0 {
+		return
+	}
+	unversioned, err := listUnversionedFiles(repoPath, snapsPath, versionedFiles)
+	if err != nil {
+		return
+	}
+	isUnversioned := map[string]bool{}
+	for _, f := range unversioned {
+		isUnversioned[f] = true
+	}
+	for _, f := range staged {
+		if !isUnversioned[f] {
+			return nil, AnError{"Staged file '" + f + "' is no longer an unversioned file"}
+		}
+	}
+	return
+}
+
+// returns the files that a commit has to snapshot: the staged ones if any,
+// all unversioned files otherwise
+func filesToCommit(repoPath string, snapsPath string, versionedFiles []string) (files []string, err error) {
+	if files, err = stagedFiles(repoPath, snapsPath, versionedFiles); err != nil || files != nil {
+		return
+	}
+	return listUnversionedFiles(repoPath, snapsPath, versionedFiles)
+}
+
+// stages the unversioned files that match the given paths. A path matches
+// a file if it is the file itself or a folder that contains it.
+func Add(paths []string) (err error) {
+	opts, err := loadConfig()
+	if err != nil {
+		return
+	}
+	b, err := InstantiateBackend(opts)
+	if err != nil {
+		return
+	}
+	if !b.IsInitialized() {
+		return AnError{"Uninitialized repository."}
+	}
+
+	versionedFiles, err := GetVersionedFiles(".")
+	if err != nil {
+		return
+	}
+	unversioned, err := listUnversionedFiles(".",
+		opts.Section("").Key("snapshots_path").String(), versionedFiles)
+	if err != nil {
+		return
+	}
+
+	staged, err := readStaged(".")
+	if err != nil {
+		return
+	}
+	for _, p := range paths {
+		p = filepath.ToSlash(filepath.Clean(p))
+		matched := false
+		for _, f := range unversioned {
+			if p == "." || f == p || strings.HasPrefix(f, p+"/") {
+				staged = append(staged, f)
+				matched = true
+			}
+		}
+		if !matched {
+			return AnError{"Path '" + p + "' did not match any unversioned files"}
+		}
+	}
+	return writeStaged(".", staged)
+}
+
+// the state of a file with respect to the latest snapshot of the current
+// revision
+type fileChange struct {
+	path string
+	kind string
+}
+
+// lists staged and unstaged files, indicating whether they are new or were
+// modified since the latest snapshot of the current revision. Files that
+// were deleted since then, or since they were staged, are listed as well.
+func ShowStatus() (statusstr string, err error) {
+	opts, err := loadConfig()
+	if err != nil {
+		return
+	}
+	b, err := InstantiateBackend(opts)
+	if err != nil {
+		return
+	}
+	if !b.IsInitialized() {
+		return "", AnError{"Uninitialized repository."}
+	}
+
+	versionedFiles, err := GetVersionedFiles(".")
+	if err != nil {
+		return
+	}
+	unversioned, err := listUnversionedFiles(".",
+		opts.Section("").Key("snapshots_path").String(), versionedFiles)
+	if err != nil {
+		return
+	}
+	staged, err := readStaged(".")
+	if err != nil {
+		return
+	}
+
+	id, err := GetCurrentCommitId(".")
+	if err != nil {
+		return
+	}
+	idx, err := b.GetVersions()
+	if err != nil {
+		return
+	}
+
+	previous := map[string]FileInfo{}
+	latest := latestOfRevision(idx, id)
+	if latest != nil {
+		files, err := b.ListFiles(latest)
+		if err != nil {
+			return "", err
+		}
+		for _, f := range files {
+			previous[f.Path] = f
+		}
+	}
+
+	isStaged := map[string]bool{}
+	for _, f := range staged {
+		isStaged[f] = true
+	}
+
+	var stagedChanges, unstagedChanges []fileChange
+	isUnversioned := map[string]bool{}
+	for _, f := range unversioned {
+		isUnversioned[f] = true
+	}
+	for _, f := range staged {
+		if !isUnversioned[f] {
+			stagedChanges = append(stagedChanges, fileChange{f, "deleted"})
+			delete(previous, f)
+		}
+	}
+	for _, f := range unversioned {
+		kind := "new"
+		if prev, ok := previous[f]; ok {
+			changed, err := changedSince(b, latest, prev, f)
+			if err != nil {
+				return "", err
+			}
+			kind = "unchanged"
+			if changed {
+				kind = "modified"
+			}
+			delete(previous, f)
+		}
+		if isStaged[f] {
+			stagedChanges = append(stagedChanges, fileChange{f, kind})
+		} else if kind != "unchanged" {
+			unstagedChanges = append(unstagedChanges, fileChange{f, kind})
+		}
+	}
+	for p := range previous {
+		unstagedChanges = append(unstagedChanges, fileChange{p, "deleted"})
+	}
+	sort.Slice(stagedChanges, func(i, j int) bool {
+		return stagedChanges[i].path < stagedChanges[j].path
+	})
+	sort.Slice(unstagedChanges, func(i, j int) bool {
+		return unstagedChanges[i].path < unstagedChanges[j].path
+	})
+
+	var buf bytes.Buffer
+	if latest != nil {
+		fmt.Fprintf(&buf, "Latest snapshot: %s#%d\n\n", latest.revision, latest.timestamp.Unix())
+	} else {
+		fmt.Fprintf(&buf, "No snapshots of revision %s yet\n\n", id)
+	}
+	if len(stagedChanges) == 0 {
+		buf.WriteString("Nothing staged, all unversioned files will be committed.\n")
+	} else {
+		buf.WriteString("Staged files:\n")
+		writeChanges(&buf, stagedChanges)
+	}
+	if len(unstagedChanges) > 0 {
+		buf.WriteString("\nUnstaged files:\n")
+		writeChanges(&buf, unstagedChanges)
+	}
+	return buf.String(), nil
+}
+
+func writeChanges(buf *bytes.Buffer, changes []fileChange) {
+	for _, c := range changes {
+		fmt.Fprintf(buf, "  %-10s %s\n", c.kind+":", c.path)
+	}
+}
+
+// whether a file of the repo differs from its copy in a version
+func changedSince(b Backend, v *version, prev FileInfo, path string) (bool, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false, err
+	}
+	// backends don't necessarily keep all permission bits, only whether a
+	// file is executable
+	if info.Mode().Type() != prev.Mode.Type() || info.Mode()&0111 != prev.Mode&0111 {
+		return true, nil
+	}
+	// backends store the target of a link as its contents, so links are
+	// compared by their target
+	if info.Mode()&os.ModeSymlink == 0 && info.Size() != prev.Size {
+		return true, nil
+	}
+
+	src, closer, err := openSource(path)
+	if err != nil {
+		return false, err
+	}
+	defer closer()
+	current, _, err := digestOf(src)
+	if err != nil {
+		return false, err
+	}
+
+	r, err := b.OpenFile(v, path)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	stored, _, err := digestOf(r)
+	if err != nil {
+		return false, err
+	}
+	return current != stored, nil
+}
@@ -0,0 +1,100 @@
+package vio
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testStaging(t *testing.T, backend string) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	err = Init(".snapshots", backend)
+	assert.Nil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile("a.txt", []byte("a\n"), 0644))
+	assert.Nil(t, os.MkdirAll("b", 0755))
+	assert.Nil(t, ioutil.WriteFile("b/c.txt", []byte("c\n"), 0644))
+	assert.Nil(t, os.Symlink("c.txt", "b/link"))
+	assert.Nil(t, ioutil.WriteFile("d.txt", []byte("d\n"), 0644))
+
+	b, err := load()
+	assert.Nil(t, err)
+	status, err := b.GetStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, status, Committed)
+
+	statusstr, err := ShowStatus()
+	assert.Nil(t, err)
+	assert.Contains(t, statusstr, "Nothing staged")
+	assert.Contains(t, statusstr, "  new:       d.txt\n")
+
+	assert.NotNil(t, Add([]string{"missing.txt"}))
+	assert.Nil(t, Add([]string{"a.txt", "b/"}))
+
+	status, err = b.GetStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, status, Staged)
+
+	statusstr, err = ShowStatus()
+	assert.Nil(t, err)
+	assert.Contains(t, statusstr,
+		"Staged files:\n  new:       a.txt\n  new:       b/c.txt\n  new:       b/link\n\nUnstaged files:\n")
+	assert.Contains(t, statusstr, "  new:       d.txt\n")
+
+	err = Commit("only staged", "{}")
+	assert.Nil(t, err)
+
+	status, err = b.GetStatus()
+	assert.Nil(t, err)
+	assert.Equal(t, status, Committed)
+
+	vs, err := b.GetVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, len(vs), 1)
+	files, err := b.ListFiles(&vs[0])
+	assert.Nil(t, err)
+	paths := []string{}
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	sort.Strings(paths)
+	assert.Equal(t, paths, []string{"a.txt", "b/c.txt", "b/link"})
+
+	statusstr, err = ShowStatus()
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(statusstr, "b/link"))
+
+	assert.Nil(t, ioutil.WriteFile("a.txt", []byte("changed\n"), 0644))
+	assert.Nil(t, os.Remove("b/c.txt"))
+	assert.Nil(t, os.Remove("b/link"))
+	assert.Nil(t, os.Symlink("../a.txt", "b/link"))
+
+	statusstr, err = ShowStatus()
+	assert.Nil(t, err)
+	assert.Contains(t, statusstr, "  modified:  a.txt\n")
+	assert.Contains(t, statusstr, "  deleted:   b/c.txt\n")
+	assert.Contains(t, statusstr, "  modified:  b/link\n")
+	assert.Contains(t, statusstr, "  new:       d.txt\n")
+
+	// a staged file that disappears is reported, and can't be committed
+	assert.Nil(t, Add([]string{"d.txt"}))
+	assert.Nil(t, os.Remove("d.txt"))
+	statusstr, err = ShowStatus()
+	assert.Nil(t, err)
+	assert.Contains(t, statusstr, "Staged files:\n  deleted:   d.txt\n")
+	assert.NotNil(t, Commit("missing staged file", "{}"))
+}
+
+func TestStagingPosix(t *testing.T) {
+	testStaging(t, "posix")
+}
+
+func TestStagingCas(t *testing.T) {
+	testStaging(t, "cas")
+}
@@ -0,0 +1,162 @@
+package vio
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// names that can be given to tags. Names that could be taken for other
+// kinds of version references are rejected.
+var tagName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._/-]*$`)
+
+func checkTagName(name string) error {
+	if !tagName.MatchString(name) || name == "latest" || name == "HEAD" {
+		return AnError{"Invalid tag name '" + name + "'"}
+	}
+	return nil
+}
+
+// parses the tags file, made of 'name rev#timestamp' lines
+func parseTags(contents []byte) (tags map[string]string, err error) {
+	tags = map[string]string{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, AnError{"Malformed tag: " + line}
+		}
+		tags[fields[0]] = fields[1]
+	}
+	return
+}
+
+func formatTags(tags map[string]string) []byte {
+	names := []string{}
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s %s\n", name, tags[name])
+	}
+	return buf.Bytes()
+}
+
+// adds a tag to a map of tags, failing if it exists already
+func addTag(tags map[string]string, name string, v *version) error {
+	if err := checkTagName(name); err != nil {
+		return err
+	}
+	if _, ok := tags[name]; ok {
+		return AnError{"Tag '" + name + "' already exists"}
+	}
+	tags[name] = v.id()
+	return nil
+}
+
+func removeTag(tags map[string]string, name string) error {
+	if _, ok := tags[name]; !ok {
+		return AnError{"Tag '" + name + "' not found"}
+	}
+	delete(tags, name)
+	return nil
+}
+
+// reads the tags stored in '<snapshots>/tags' by the backends that keep
+// the index in the local filesystem. Repos without tags have no such file.
+func readTagsFile(snapsPath string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(snapsPath + "/tags")
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseTags(contents)
+}
+
+// modifies the tags stored in '<snapshots>/tags', holding the lock of the
+// index while doing so
+func updateTagsFile(snapsPath string, f func(tags map[string]string) error) (err error) {
+	flock, err := lockIndex(snapsPath + "/index")
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	tags, err := readTagsFile(snapsPath)
+	if err != nil {
+		return
+	}
+	if err = f(tags); err != nil {
+		return
+	}
+	return ioutil.WriteFile(snapsPath+"/tags", formatTags(tags), 0644)
+}
+
+// returns the tags of each version, indexed by version ID
+func tagsByVersion(tags map[string]string) map[string][]string {
+	byVersion := map[string][]string{}
+	for name, id := range tags {
+		byVersion[id] = append(byVersion[id], name)
+	}
+	for _, names := range byVersion {
+		sort.Strings(names)
+	}
+	return byVersion
+}
+
+// tags a version. If force is true, an existing tag with the same name is
+// moved instead of causing an error.
+func Tag(name string, ref string, force bool) (err error) {
+	b, err := load()
+	if err != nil {
+		return
+	}
+	v, err := resolveVersion(b, ref)
+	if err != nil {
+		return
+	}
+	if force {
+		tags, err := b.GetTags()
+		if err != nil {
+			return err
+		}
+		if _, ok := tags[name]; ok {
+			if err = b.DeleteTag(name); err != nil {
+				return err
+			}
+		}
+	}
+	return b.SetTag(name, v)
+}
+
+func DeleteTag(name string) (err error) {
+	b, err := load()
+	if err != nil {
+		return
+	}
+	return b.DeleteTag(name)
+}
+
+// lists tags along with the version they refer to
+func ListTags() (tagstr string, err error) {
+	b, err := load()
+	if err != nil {
+		return
+	}
+	tags, err := b.GetTags()
+	if err != nil {
+		return
+	}
+	return string(formatTags(tags)), nil
+}
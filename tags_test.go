@@ -0,0 +1,97 @@
+package vio
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags([]byte("paper-fig3 ca82a6d#1448281434\nbaseline ca82a6d#1448304512\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, tags, map[string]string{
+		"paper-fig3": "ca82a6d#1448281434",
+		"baseline":   "ca82a6d#1448304512"})
+	assert.Equal(t, string(formatTags(tags)),
+		"baseline ca82a6d#1448304512\npaper-fig3 ca82a6d#1448281434\n")
+
+	_, err = parseTags([]byte("no-version\n"))
+	assert.NotNil(t, err)
+
+	for _, name := range []string{"", "latest", "HEAD", "a b", "-d", "v1~2", "v#1", "@"} {
+		assert.NotNil(t, checkTagName(name), name)
+	}
+	for _, name := range []string{"paper-fig3", "baseline-v2", "release/1.0", "v1.2_rc"} {
+		assert.Nil(t, checkTagName(name), name)
+	}
+}
+
+func testTags(t *testing.T, backend string) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	err = Init(".snapshots", backend)
+	assert.Nil(t, err)
+
+	id, err := GetCurrentCommitId(path)
+	assert.Nil(t, err)
+	for _, ts := range []string{"1448281434", "1448304512"} {
+		v := NewVersionWithMeta(id+"#"+ts, metadata{"message": "run " + ts})
+		assert.Nil(t, addVersionToIndex(v, ".snapshots/index"))
+	}
+
+	tagstr, err := ListTags()
+	assert.Nil(t, err)
+	assert.Equal(t, tagstr, "")
+
+	assert.Nil(t, Tag("paper-fig3", id+"#1448281434", false))
+	assert.Nil(t, Tag("baseline", "latest", false))
+	assert.NotNil(t, Tag("baseline", "@~1", false))
+	assert.NotNil(t, Tag("latest", "@", false))
+	assert.NotNil(t, Tag("missing", "no-such-version", false))
+
+	tagstr, err = ListTags()
+	assert.Nil(t, err)
+	assert.Equal(t, tagstr, "baseline "+id+"#1448304512\npaper-fig3 "+id+"#1448281434\n")
+
+	b, err := load()
+	assert.Nil(t, err)
+	v, err := resolveVersion(b, "paper-fig3")
+	assert.Nil(t, err)
+	assert.Equal(t, v.meta["message"], "run 1448281434")
+
+	logstr, err := Log()
+	assert.Nil(t, err)
+	assert.Equal(t, logstr,
+		id+"#1448281434 (paper-fig3) run 1448281434\n"+
+			id+"#1448304512 (baseline) run 1448304512\n")
+
+	// moving a tag requires forcing it
+	assert.Nil(t, Tag("baseline", "@~1", true))
+	v, err = resolveVersion(b, "baseline")
+	assert.Nil(t, err)
+	assert.Equal(t, v.id(), id+"#1448281434")
+
+	assert.Nil(t, DeleteTag("paper-fig3"))
+	assert.NotNil(t, DeleteTag("paper-fig3"))
+	_, err = resolveVersion(b, "paper-fig3")
+	assert.NotNil(t, err)
+
+	logstr, err = LogWithOptions(LogOptions{Format: "full"})
+	assert.Nil(t, err)
+	assert.Contains(t, logstr, "version "+id+"#1448281434\nTags: baseline\nDate: ")
+}
+
+func TestTagsPosix(t *testing.T) {
+	testTags(t, "posix")
+}
+
+func TestTagsGit(t *testing.T) {
+	testTags(t, "git")
+}
+
+func TestTagsCas(t *testing.T) {
+	testTags(t, "cas")
+}
@@ -0,0 +1,227 @@
+package vio
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// lists the regular files and symlinks in a folder, relative to it
+func listDir(dir string) (files []string, err error) {
+	files = []string{}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return
+}
+
+// copies the regular files and symlinks of a folder into another one
+func copyDir(src string, dest string) (err error) {
+	files, err := listDir(src)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(dest, 0755); err != nil {
+		return
+	}
+	for _, file := range files {
+		info, err := os.Lstat(src + "/" + file)
+		if err != nil {
+			return err
+		}
+		r, closer, err := openSource(src + "/" + file)
+		if err != nil {
+			return err
+		}
+		err = restoreFile(r, dest+"/"+file, info.Mode())
+		closer()
+		if err != nil {
+			return err
+		}
+	}
+	return
+}
+
+// writes the files of a version into a folder
+func exportSnapshot(b Backend, v *version, dir string) (err error) {
+	files, err := b.ListFiles(v)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	for _, f := range files {
+		r, err := b.OpenFile(v, f.Path)
+		if err != nil {
+			return err
+		}
+		err = restoreFile(r, dir+"/"+f.Path, f.Mode)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return
+}
+
+// copies versions from one repository to another, along with their tags
+// and annotations. Only the versions given by refs are copied, or all of
+// them if there are none. Versions that are in both repositories but have
+// different metadata are conflicts, in which case nothing is copied. The
+// report describes the copies with the given verb, e.g. 'pushed'.
+func syncVersions(src Backend, dst Backend, refs []string, verb string) (report string, err error) {
+	srcIdx, err := src.GetVersions()
+	if err != nil {
+		return
+	}
+	selected := map[string]bool{}
+	for _, ref := range refs {
+		v, err := resolveVersion(src, ref)
+		if err != nil {
+			return "", err
+		}
+		selected[v.id()] = true
+	}
+	versions := []version{}
+	for _, v := range srcIdx {
+		if len(refs) == 0 || selected[v.id()] {
+			versions = append(versions, v)
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].timestamp.Before(versions[j].timestamp) })
+
+	dstIdx, err := dst.GetVersions()
+	if err != nil {
+		return
+	}
+	existing := map[string]version{}
+	for _, v := range dstIdx {
+		existing[v.id()] = v
+	}
+
+	var conflicts bytes.Buffer
+	missing := []version{}
+	for _, v := range versions {
+		other, ok := existing[v.id()]
+		if !ok {
+			missing = append(missing, v)
+		} else if !v.meta.equal(other.meta) {
+			fmt.Fprintf(&conflicts, "\n  %s", v.id())
+		}
+	}
+	if conflicts.Len() > 0 {
+		return "", AnError{"Versions with different metadata in both repositories:" + conflicts.String()}
+	}
+
+	tmp, err := ioutil.TempDir("", "vio-transfer-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmp)
+
+	var buf bytes.Buffer
+	for i := range missing {
+		v := &missing[i]
+		dir := fmt.Sprintf("%s/%d", tmp, i)
+		if err = exportSnapshot(src, v, dir); err != nil {
+			return
+		}
+		if err = dst.Import(v, dir); err != nil {
+			return
+		}
+		if err = os.RemoveAll(dir); err != nil {
+			return
+		}
+		fmt.Fprintf(&buf, "%s %s %s\n", verb, v.id(), v.meta.str("message"))
+	}
+
+	copied := map[string]bool{}
+	for _, v := range versions {
+		copied[v.id()] = true
+	}
+	if err = syncAnnotations(src, dst, copied); err != nil {
+		return
+	}
+	if err = syncTags(src, dst, versions, &buf); err != nil {
+		return
+	}
+
+	fmt.Fprintf(&buf, "%d versions %s, %d already present\n",
+		len(missing), verb, len(versions)-len(missing))
+	return buf.String(), nil
+}
+
+// copies the annotations of the given versions that dst lacks
+func syncAnnotations(src Backend, dst Backend, versions map[string]bool) (err error) {
+	srcAnnotations, err := src.GetAnnotations()
+	if err != nil {
+		return
+	}
+	dstAnnotations, err := dst.GetAnnotations()
+	if err != nil {
+		return
+	}
+	existing := map[string]bool{}
+	for _, a := range dstAnnotations {
+		line, err := formatAnnotation(a)
+		if err != nil {
+			return err
+		}
+		existing[string(line)] = true
+	}
+	for _, a := range srcAnnotations {
+		line, err := formatAnnotation(a)
+		if err != nil {
+			return err
+		}
+		if !versions[a.Version] || existing[string(line)] {
+			continue
+		}
+		if err = dst.Annotate(a); err != nil {
+			return err
+		}
+	}
+	return
+}
+
+// copies the tags of the given versions that dst lacks. Tags that dst has
+// for another version are left alone and reported.
+func syncTags(src Backend, dst Backend, versions []version, report *bytes.Buffer) (err error) {
+	srcTags, err := src.GetTags()
+	if err != nil {
+		return
+	}
+	dstTags, err := dst.GetTags()
+	if err != nil {
+		return
+	}
+	byVersion := tagsByVersion(srcTags)
+	for i, v := range versions {
+		for _, name := range byVersion[v.id()] {
+			id, ok := dstTags[name]
+			if !ok {
+				if err = dst.SetTag(name, &versions[i]); err != nil {
+					return
+				}
+			} else if id != v.id() {
+				fmt.Fprintf(report, "tag %s not updated, it refers to %s in destination\n", name, id)
+			}
+		}
+	}
+	return
+}
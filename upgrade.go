@@ -0,0 +1,60 @@
+package vio
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+// migrates the index of the backends that keep it in the local filesystem
+// to the current format, holding its lock. The previous index is kept in
+// '<snapshots>/index.bak'.
+func upgradeIndexFile(snapsPath string) (from int, err error) {
+	filename := snapsPath + "/index"
+	flock, err := lockIndex(filename)
+	if err != nil {
+		return
+	}
+	defer flock.Unlock()
+
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	idx, err := decodeIndex(contents)
+	if err != nil {
+		return
+	}
+	if from = idx.format; from == indexFormat {
+		return
+	}
+	if err = idx.upgrade(); err != nil {
+		return
+	}
+	upgraded, err := idx.encode()
+	if err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(snapsPath+"/index.bak", contents, 0644); err != nil {
+		return
+	}
+	// rewritten in place, since the lock is tied to the file
+	err = ioutil.WriteFile(filename, upgraded, 0644)
+	return
+}
+
+// migrates the repository to the formats used by this version of vio
+func Upgrade() (report string, err error) {
+	b, err := load()
+	if err != nil {
+		return
+	}
+	from, err := b.UpgradeIndex()
+	if err != nil {
+		return
+	}
+	if from == indexFormat {
+		return fmt.Sprintf("index is up to date (format %d)\n", indexFormat), nil
+	}
+	return fmt.Sprintf("index upgraded from format %d to %d, the previous one is kept in index.bak\n",
+		from, indexFormat), nil
+}
@@ -43,6 +43,22 @@ func HasUncommittedChanges(repoPath string) (has bool, err error) {
 	return
 }
 
+// returns the versioned files that differ from the current commit, staged or
+// not
+func GetModifiedFiles(repoPath string) (modified []string, err error) {
+	out, err := runCmd(repoPath, "git diff --name-only HEAD")
+	if err != nil {
+		return
+	}
+	modified = []string{}
+	for _, path := range strings.Split(strings.TrimSpace(out), "\n") {
+		if path != "" {
+			modified = append(modified, path)
+		}
+	}
+	return
+}
+
 func GetVersionedFiles(repoPath string) (versioned []string, err error) {
 	out, err := runCmd(repoPath, "git ls-files")
 	if err != nil {
@@ -60,3 +76,31 @@ func GetCurrentCommitId(repoPath string) (id string, err error) {
 	id = strings.TrimSpace(out)
 	return
 }
+
+func GetCurrentBranch(repoPath string) (branch string, err error) {
+	out, err := runCmd(repoPath, "git rev-parse --abbrev-ref HEAD")
+	if err != nil {
+		return
+	}
+	branch = strings.TrimSpace(out)
+	return
+}
+
+func GetRemoteURL(repoPath string) (url string, err error) {
+	out, err := runCmd(repoPath, "git config --get remote.origin.url")
+	if err != nil {
+		return
+	}
+	url = strings.TrimSpace(out)
+	return
+}
+
+// returns the commits that are reachable from any branch
+func GetReachableCommits(repoPath string) (commits []string, err error) {
+	out, err := runCmd(repoPath, "git rev-list --branches")
+	if err != nil {
+		return
+	}
+	commits = strings.Fields(out)
+	return
+}
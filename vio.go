@@ -3,6 +3,7 @@ package vio
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -21,47 +22,44 @@ const (
 type version struct {
 	revision  string
 	timestamp time.Time
-	meta      map[string]string
+	meta      metadata
 }
 
-func NewVersion(revision string) *version {
-	fields := strings.Split(revision, "#")
+// parses a 'revision#timestamp' string. The current time is used if there's
+// no timestamp.
+func parseVersion(str string, meta metadata) (*version, error) {
+	fields := strings.Split(str, "#")
+	if len(fields) > 2 || fields[0] == "" {
+		return nil, AnError{"Malformed version '" + str + "'"}
+	}
 
+	timestamp := time.Now()
 	if len(fields) == 2 {
 		i, err := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
 		if err != nil {
-			panic(err)
+			return nil, AnError{"Malformed timestamp in version '" + str + "'"}
 		}
-		return &version{
-			revision:  fields[0],
-			timestamp: time.Unix(i, 0),
-			meta:      map[string]string{}}
-	} else {
-		return &version{
-			revision:  fields[0],
-			timestamp: time.Now(),
-			meta:      map[string]string{}}
+		timestamp = time.Unix(i, 0)
 	}
+	return &version{
+		revision:  fields[0],
+		timestamp: timestamp,
+		meta:      meta}, nil
 }
 
-func NewVersionWithMeta(revision string, meta map[string]string) *version {
-	fields := strings.Split(revision, "#")
+// creates a version from a well-formed 'revision#timestamp' string; it
+// panics otherwise. Versions given by users are resolved with
+// resolveVersion instead.
+func NewVersion(revision string) *version {
+	return NewVersionWithMeta(revision, metadata{})
+}
 
-	if len(fields) == 2 {
-		i, err := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
-		if err != nil {
-			panic(err)
-		}
-		return &version{
-			revision:  fields[0],
-			timestamp: time.Unix(i, 0),
-			meta:      meta}
-	} else {
-		return &version{
-			revision:  fields[0],
-			timestamp: time.Now(),
-			meta:      meta}
+func NewVersionWithMeta(revision string, meta metadata) *version {
+	v, err := parseVersion(revision, meta)
+	if err != nil {
+		panic(err)
 	}
+	return v
 }
 
 func ContainsVersion(vs []version, v *version) bool {
@@ -73,6 +71,11 @@ func ContainsVersion(vs []version, v *version) bool {
 	return false
 }
 
+// returns the 'revision#timestamp' string that identifies a version
+func (v *version) id() string {
+	return fmt.Sprintf("%s#%d", v.revision, v.timestamp.Unix())
+}
+
 func (v *version) String() string {
 	s, err := json.Marshal(v.meta)
 	if err != nil {
@@ -81,6 +84,13 @@ func (v *version) String() string {
 	return fmt.Sprintf("%s#%d,%s", v.revision, v.timestamp.Unix(), s)
 }
 
+// describes a file contained in a version
+type FileInfo struct {
+	Path string
+	Size int64
+	Mode os.FileMode
+}
+
 type Backend interface {
 	// inits backend in current directory
 	Init() error
@@ -98,13 +108,60 @@ type Backend interface {
 	Checkout(v *version) error
 
 	// commits a version.
-	Commit(meta map[string]string) (*version, error)
+	Commit(meta metadata) (*version, error)
 
 	// retrieves the string representation of the diff for a path
 	Diff(v1 *version, v2 *version, path string) (string, error)
 
+	// lists the files contained in a version
+	ListFiles(v *version) ([]FileInfo, error)
+
+	// opens a file contained in a version for reading
+	OpenFile(v *version, path string) (io.ReadCloser, error)
+
 	// returns list of committed versions
 	GetVersions() (versions []version, err error)
+
+	// returns the tags, mapping their names to version IDs
+	GetTags() (map[string]string, error)
+
+	// tags a version, failing if the tag exists already
+	SetTag(name string, v *version) error
+
+	// removes a tag
+	DeleteTag(name string) error
+
+	// returns the annotations of all versions, in the order they were made
+	GetAnnotations() ([]annotation, error)
+
+	// records an annotation
+	Annotate(a annotation) error
+
+	// removes versions from the index, leaving their files in place until
+	// they are garbage-collected
+	Remove(vs []*version) error
+
+	// removes the stored files of versions removed from the index, and
+	// those that no version refers to. Returns what was removed (or would
+	// be, if dryRun is true) and the number of bytes freed.
+	GC(dryRun bool) (removed []string, freed int64, err error)
+
+	// stores the files in a folder as the snapshot of a version, and adds
+	// the version to the index along with its metadata. Used to copy
+	// versions between repositories.
+	Import(v *version, dir string) error
+
+	// checks the integrity of the index and the stored snapshots, fixing
+	// what can be fixed if repair is true
+	Fsck(repair bool) ([]problem, error)
+
+	// rebuilds the index from the snapshots in storage, returning the
+	// versions it has. With dryRun, the index is left untouched.
+	Reindex(dryRun bool) ([]version, error)
+
+	// migrates the index to the current format, keeping the previous one
+	// in 'index.bak'. Returns the format it was in.
+	UpgradeIndex() (int, error)
 }
 
 type AnError struct {
@@ -116,18 +173,97 @@ func (e AnError) Error() string {
 }
 
 func InstantiateBackend(opts *ini.File) (backend Backend, err error) {
-	opts.Section("").Key("repo_path").SetValue(".")
+	return instantiateBackendAt(opts, ".")
+}
+
+// instantiates a backend for a checkout of the project other than the
+// current directory, such as a worktree
+func instantiateBackendAt(opts *ini.File, repoPath string) (backend Backend, err error) {
+	opts.Section("").Key("repo_path").SetValue(repoPath)
 	backendType := opts.Section("").Key("backend_type").Value()
 	switch backendType {
 	case "posix":
 		backend, err = NewPosixBackend(opts)
+	case "git":
+		backend, err = NewGitBackend(opts)
+	case "git-lfs":
+		backend, err = NewGitLfsBackend(opts)
+	case "cas":
+		backend, err = NewCasBackend(opts)
+	case "s3":
+		backend, err = NewS3Backend(opts)
 	default:
 		return nil, AnError{"unknown backend " + backendType}
 	}
 	return
 }
 
+// checks that a backend is initialized and that the repo has no uncommitted
+// changes, so that snapshots can be associated to its current revision
+func checkRepo(b Backend, repoPath string) (err error) {
+	if !b.IsInitialized() {
+		return AnError{"Uninitialized repository."}
+	}
+
+	hasUncommitted, err := HasUncommittedChanges(repoPath)
+
+	if err != nil {
+		return
+	}
+	if hasUncommitted {
+		return AnError{"Uncommitted changes in repo."}
+	}
+
+	return
+}
+
+// checks that a version with the given metadata can be committed. It's
+// like checkRepo, except that uncommitted changes are accepted if the
+// metadata lists them in 'run.dirty_paths', as Run does when the command
+// it executes modifies versioned files.
+func checkCommit(b Backend, repoPath string, meta metadata) (err error) {
+	if _, ok := meta["run.dirty_paths"]; !ok {
+		return checkRepo(b, repoPath)
+	}
+	if !b.IsInitialized() {
+		return AnError{"Uninitialized repository."}
+	}
+	modified, err := GetModifiedFiles(repoPath)
+	if err != nil {
+		return
+	}
+	expected, err := json.Marshal(modified)
+	if err != nil {
+		return
+	}
+	if meta.str("run.dirty_paths") != string(expected) {
+		return AnError{"Uncommitted changes in repo."}
+	}
+	return
+}
+
+// checks that the given versions are in the index of a backend
+func checkInIndex(b Backend, vs ...*version) error {
+	idx, err := b.GetVersions()
+	if err != nil {
+		return err
+	}
+	for _, v := range vs {
+		if !ContainsVersion(idx, v) {
+			return AnError{
+				fmt.Sprintf("Version %s#%d not in index", v.revision, v.timestamp.Unix())}
+		}
+	}
+	return nil
+}
+
 func Init(snapsPath string, backend string) (err error) {
+	return InitWithOptions(snapsPath, backend, map[string]string{})
+}
+
+// initializes a repository, storing the given backend-specific options in
+// the configuration file
+func InitWithOptions(snapsPath string, backend string, options map[string]string) (err error) {
 	if _, err = os.Stat(".vioconfig"); err == nil {
 		return
 	}
@@ -136,6 +272,9 @@ func Init(snapsPath string, backend string) (err error) {
 	opts.Section("").Key("repo_path").SetValue(".")
 	opts.Section("").Key("snapshots_path").SetValue(snapsPath)
 	opts.Section("").Key("backend_type").SetValue(backend)
+	for k, v := range options {
+		opts.Section("").Key(k).SetValue(v)
+	}
 
 	b, err := InstantiateBackend(opts)
 	if err != nil {
@@ -152,8 +291,12 @@ func Init(snapsPath string, backend string) (err error) {
 	return opts.SaveTo(".vioconfig")
 }
 
+func loadConfig() (*ini.File, error) {
+	return ini.Load(".vioconfig")
+}
+
 func load() (b Backend, err error) {
-	opts, err := ini.Load(".vioconfig")
+	opts, err := loadConfig()
 	if err != nil {
 		return
 	}
@@ -162,45 +305,64 @@ func load() (b Backend, err error) {
 }
 
 func Commit(message string, meta string) (err error) {
-	var t map[string]string
-	err = json.Unmarshal([]byte(meta), &t)
+	t, err := parseMetadata([]byte(meta))
 	if err != nil {
 		return AnError{"Error while unmarshaling JSON: " + err.Error()}
 	}
-	b, err := load()
+	opts, err := loadConfig()
+	if err != nil {
+		return
+	}
+	b, err := InstantiateBackend(opts)
 	if err != nil {
 		return
 	}
 	t["message"] = message
+	if err = addExtracted(t, opts, "."); err != nil {
+		return
+	}
+	addProvenance(t, opts)
 	_, err = b.Commit(t)
 
 	return
 }
 
-func Log() (logstr string, err error) {
+func Checkout(v_str string) (err error) {
 	b, err := load()
 	if err != nil {
 		return
 	}
-	versions, err := b.GetVersions()
+	v, err := resolveVersion(b, v_str)
 	if err != nil {
 		return
 	}
-	for _, v := range versions {
-		logstr = logstr +
-			fmt.Sprintf("%s#%d %s\n",
-				v.revision,
-				v.timestamp.Unix(),
-				v.meta["message"])
+	return b.Checkout(v)
+}
+
+func Diff(v1_str string, v2_str string, path string) (diffstr string, err error) {
+	b, err := load()
+	if err != nil {
+		return
 	}
-	return
+	v1, err := resolveVersion(b, v1_str)
+	if err != nil {
+		return
+	}
+	v2, err := resolveVersion(b, v2_str)
+	if err != nil {
+		return
+	}
+	return b.Diff(v1, v2, path)
 }
 
-func Checkout(v_str string) (err error) {
+func Show(v_str string, stat bool) (showstr string, err error) {
 	b, err := load()
 	if err != nil {
 		return
 	}
-	v := NewVersion(v_str)
-	return b.Checkout(v)
+	v, err := resolveVersion(b, v_str)
+	if err != nil {
+		return
+	}
+	return showVersion(b, v, stat)
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var addCmd = &cobra.Command{
+	Use:   "add <path>...",
+	Short: "Stage unversioned files for the next commit.",
+	Long: `Stages unversioned files, or all the unversioned files inside a folder. When
+files are staged, 'vio commit' only snapshots them instead of every
+unversioned file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Fatalln("Expecting at least one path")
+		}
+		if err := vio.Add(args); err != nil {
+			log.Fatalln(err.Error())
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(addCmd)
+}
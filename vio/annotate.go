@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var annotateSet []string
+var annotateUnset []string
+var annotateMsg string
+
+var annotateCmd = &cobra.Command{
+	Use:   "annotate <version>",
+	Short: "Change the metadata of a version.",
+	Long: `Sets or removes metadata keys of a committed version, or replaces its message.
+Changes are recorded along with who made them and when, and 'vio log' shows
+the current values. Without --set, --unset or --message, shows the history
+of changes of the version.
+` + versionHelp,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalln("Expecting a version")
+		}
+
+		set := map[string]string{}
+		for _, kv := range annotateSet {
+			fields := strings.SplitN(kv, "=", 2)
+			if len(fields) != 2 {
+				log.Fatalln("Expecting metadata of the form key=value, got " + kv)
+			}
+			set[fields[0]] = fields[1]
+		}
+		if cmd.Flags().Changed("message") {
+			// quoted, so that it's kept as a string
+			msg, err := json.Marshal(annotateMsg)
+			if err != nil {
+				log.Fatalln(err.Error())
+			}
+			set["message"] = string(msg)
+		}
+
+		if len(set) == 0 && len(annotateUnset) == 0 {
+			history, err := vio.AnnotationHistory(args[0])
+			if err != nil {
+				log.Fatalln(err.Error())
+			}
+			fmt.Print(history)
+			return
+		}
+
+		if err := vio.Annotate(args[0], set, annotateUnset); err != nil {
+			log.Fatalln(err.Error())
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(annotateCmd)
+	annotateCmd.Flags().StringArrayVarP(&annotateSet,
+		"set", "", []string{}, "Metadata of the form key=value, with the value parsed as JSON if valid.")
+	annotateCmd.Flags().StringArrayVarP(&annotateUnset,
+		"unset", "", []string{}, "Metadata key to remove.")
+	annotateCmd.Flags().StringVarP(&annotateMsg,
+		"message", "m", "", "New message of the version.")
+}
@@ -8,12 +8,13 @@ import (
 )
 
 var checkoutCmd = &cobra.Command{
-	Use:   "checkout",
+	Use:   "checkout <version>",
 	Short: "Checks out a version.",
-	Long:  ``,
+	Long: `Restores the files of a version in the working directory.
+` + versionHelp,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) != 1 {
-			log.Fatalln("Expecting revision ID")
+			log.Fatalln("Expecting a version")
 		}
 		if err := vio.Checkout(args[0]); err != nil {
 			log.Fatalln(err.Error())
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone <path>",
+	Short: "Initialize a repo with the versions of another one.",
+	Long: `Initializes a vio repository, adds the snapshots folder at <path> as the
+'origin' remote and pulls all its versions. Like 'vio init', it has to be
+executed at the root of a git repository (usually a clone of the project the
+versions were committed from).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalln("Expecting the path of the snapshots to clone")
+		}
+		report, err := vio.Clone(args[0], snapPath, backend)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(report)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(cloneCmd)
+	cloneCmd.Flags().StringVarP(&snapPath,
+		"snapshots", "s", ".snapshots", "Path to where snapshots are stored")
+	cloneCmd.Flags().StringVarP(&backend,
+		"backend", "b", "posix", "Backend to manage snapshots")
+}
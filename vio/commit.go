@@ -24,7 +24,7 @@ var commitCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(commitCmd)
 	commitCmd.Flags().StringVarP(&meta,
-		"meta", "", "{}", "JSON-formatted string of key-value pairs.")
+		"meta", "", "{}", "JSON object with the metadata of the version.")
 	commitCmd.Flags().StringVarP(&msg,
 		"message", "m", " ", "Commit message.")
 }
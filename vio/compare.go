@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var compareOpts vio.CompareOptions
+
+var compareCmd = &cobra.Command{
+	Use:   "compare [<version>...] --keys <key>,...",
+	Short: "Compare values across versions.",
+	Long: `Shows a table with the values of the given keys in each version (all of them
+if none are given), along with their min, max and mean, and the change of each
+value relative to the baseline version (the first one, unless --baseline says
+otherwise). A key is either a metadata key or a field of a JSON or CSV file of
+the snapshots, written 'file:field':
+
+    vio compare @~1 @ --keys run.wall_time,results.json:throughput,stats.csv:latency
+
+JSON fields are paths of object keys and array indexes separated by dots (e.g.
+'results.json:runs.0.latency'). CSV fields are column names, whose value in the
+last row is taken, optionally preceded by a row index (e.g. 'stats.csv:0.latency').`,
+	Run: func(cmd *cobra.Command, args []string) {
+		table, err := vio.Compare(args, compareOpts)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(table)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(compareCmd)
+	compareCmd.Flags().StringSliceVarP(&compareOpts.Keys,
+		"keys", "k", []string{}, "Comma-separated list of keys to compare.")
+	compareCmd.Flags().StringVarP(&compareOpts.Baseline,
+		"baseline", "b", "", "Version that changes are relative to.")
+	compareCmd.Flags().StringVarP(&compareOpts.Format,
+		"format", "", "text", "Output format: text, csv, markdown or json.")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <version> <version> [path]",
+	Short: "Show changes between two versions.",
+	Long: `Lists the files added, removed and modified between two versions and
+shows unified diffs for modified text files. An optional path restricts the
+comparison to a file or folder.
+` + versionHelp,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 && len(args) != 3 {
+			log.Fatalln("Expecting two versions and an optional path")
+		}
+		path := ""
+		if len(args) == 3 {
+			path = args[2]
+		}
+		diffstr, err := vio.Diff(args[0], args[1], path)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(diffstr)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var exportOutput string
+var exportOpts vio.LogOptions
+
+var exportCmd = &cobra.Command{
+	Use:   "export [<version>...] -o <file>",
+	Short: "Write versions to a bundle.",
+	Long: `Writes the given versions, or all of them if none is given, to a bundle
+that can be imported into another repository with 'vio import'. The bundle
+is a tar archive with the files of the versions, their index entries, tags
+and annotations, and a manifest with the SHA-256 checksum of each file. It
+is compressed with zstd if its name ends with '.zst' (the zstd tool has to
+be installed) or gzip if it ends with '.gz' or '.tgz'.
+
+Versions can be further selected by git revision, time range and metadata,
+as in 'vio log'. For example:
+
+    vio export --rev main --since 2024-03-01 -o runs.tar.zst
+` + versionHelp,
+	Run: func(cmd *cobra.Command, args []string) {
+		if exportOutput == "" {
+			log.Fatalln("Expecting the name of the bundle (-o)")
+		}
+		report, err := vio.Export(exportOutput, args, exportOpts)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(report)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVarP(&exportOutput,
+		"output", "o", "", "Bundle to write.")
+	exportCmd.Flags().StringVarP(&exportOpts.Since,
+		"since", "", "", "Export versions committed after a date or time ago (e.g. 7d).")
+	exportCmd.Flags().StringVarP(&exportOpts.Until,
+		"until", "", "", "Export versions committed before a date or time ago.")
+	exportCmd.Flags().StringVarP(&exportOpts.Revision,
+		"rev", "", "", "Export versions of a git commit.")
+	exportCmd.Flags().StringArrayVarP(&exportOpts.Where,
+		"where", "w", []string{}, "Export versions whose metadata satisfies a predicate.")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var fsckRepair bool
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check the integrity of the repository.",
+	Long: `Checks that every line of the index is well-formed and refers to a stored
+snapshot, that every stored snapshot is in the index (unless it was removed with
+'vio rm'), and that the files of each snapshot match the checksums recorded when
+it was committed. With --repair, malformed index lines and lines of missing
+snapshots are removed (the original index is kept in 'index.bak'), snapshots
+missing from the index are added back as 'vio reindex' does, and missing
+checksums are recorded from the current contents of the snapshots.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		report, err := vio.Fsck(fsckRepair)
+		fmt.Print(report)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(fsckCmd)
+	fsckCmd.Flags().BoolVar(&fsckRepair, "repair", false, "Fix the problems that can be fixed.")
+}
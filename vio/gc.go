@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var gcDryRun bool
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove data that no version refers to.",
+	Long: `Removes the snapshots of versions removed from the index with 'vio rm',
+as well as stored files that no version refers to. Snapshots missing from the
+index for any other reason are kept, so that 'vio reindex' can recover them.
+With --dry-run, shows what would be removed and how much space would be freed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		report, err := vio.GC(gcDryRun)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(report)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(gcCmd)
+	gcCmd.Flags().BoolVarP(&gcDryRun, "dry-run", "n", false, "Only show what would be removed.")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import the versions of a bundle.",
+	Long: `Verifies a bundle written by 'vio export' and copies the versions it
+contains, along with their tags and annotations, into the repository.
+Nothing is imported if a file doesn't match its checksum, or if a version
+is in both the bundle and the repository with different metadata.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalln("Expecting the bundle to import")
+		}
+		report, err := vio.Import(args[0])
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(report)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(importCmd)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"strings"
 
 	"github.com/ivotron/vio"
 	"github.com/spf13/cobra"
@@ -9,13 +10,26 @@ import (
 
 var snapPath string
 var backend string
+var options []string
 
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initializes the vio repo.",
-	Long:  `Initializes a vio repository. The value for --backend can be one of 'git', 'posix' or 'git-lfs'`,
+	Long: `Initializes a vio repository. The value for --backend can be one of 'git', 'posix', 'git-lfs', 'cas' or 's3'.
+
+Backend-specific options are stored in the .vioconfig file and can be given
+with --option, e.g. '--option incremental=true' for the posix backend or
+'--option s3_bucket=mybucket' for the s3 backend.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := vio.Init(snapPath, backend); err != nil {
+		opts := map[string]string{}
+		for _, o := range options {
+			kv := strings.SplitN(o, "=", 2)
+			if len(kv) != 2 {
+				log.Fatalln("Expecting option of the form key=value, got " + o)
+			}
+			opts[kv[0]] = kv[1]
+		}
+		if err := vio.InitWithOptions(snapPath, backend, opts); err != nil {
 			log.Fatalln(err.Error())
 		}
 	},
@@ -27,4 +41,6 @@ func init() {
 		"snapshots", "s", ".snapshots", "Path to where snapshots are stored")
 	initCmd.Flags().StringVarP(&backend,
 		"backend", "b", "posix", "Backend to manage snapshots")
+	initCmd.Flags().StringArrayVarP(&options,
+		"option", "o", []string{}, "Backend option of the form key=value")
 }
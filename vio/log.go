@@ -8,12 +8,20 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var logOpts vio.LogOptions
+
 var logCmd = &cobra.Command{
 	Use:   "log",
 	Short: "Show log info.",
-	Long:  ``,
+	Long: `Lists committed versions, optionally filtered by git revision, time range
+and metadata. For example, to show all runs with 64 threads from last week:
+
+    vio log --since 7d --where threads=64
+
+Predicates given with --where have the form key=value, key!=value or key<op>number
+(with <op> one of >, <, >=, <=) and all of them have to hold.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		logstr, err := vio.Log()
+		logstr, err := vio.LogWithOptions(logOpts)
 		if err != nil {
 			log.Fatalln(err.Error())
 		}
@@ -23,4 +31,14 @@ var logCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(logCmd)
+	logCmd.Flags().StringVarP(&logOpts.Format,
+		"format", "", "oneline", "Output format: oneline, full, json or csv.")
+	logCmd.Flags().StringVarP(&logOpts.Since,
+		"since", "", "", "Show versions committed after a date or time ago (e.g. 7d).")
+	logCmd.Flags().StringVarP(&logOpts.Until,
+		"until", "", "", "Show versions committed before a date or time ago.")
+	logCmd.Flags().StringVarP(&logOpts.Revision,
+		"rev", "", "", "Show versions of a git commit.")
+	logCmd.Flags().StringArrayVarP(&logOpts.Where,
+		"where", "w", []string{}, "Show versions whose metadata satisfies a predicate.")
 }
@@ -18,6 +18,13 @@ var RootCmd = &cobra.Command{
 	},
 }
 
+// describes the references accepted by commands that take a version
+const versionHelp = `
+A version can be given as its full ID (e.g. ca82a6d#1448281434), a unique
+prefix of it, 'latest' (or '@') for the newest version, '@~<n>' for the n-th
+version before the newest, or a git revision (e.g. HEAD or main~3) for the
+newest version of that commit.`
+
 func main() {
 	// check if have access to dependencies
 	if _, err := exec.Command("git", "--version").Output(); err != nil {
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var pruneDryRun bool
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove versions according to the retention rules.",
+	Long: `Applies the retention rules in the [retention] section of .vioconfig,
+removing the versions they don't keep along with their files. For example:
+
+  [retention]
+  keep_last = 3          # latest 3 versions of each git commit
+  keep_daily = 7         # latest version of each of the last 7 days
+  keep_weekly = 4        # ... of each of the last 4 weeks
+  keep_monthly = 12      # ... of each of the last 12 months
+  drop_unreachable = yes # versions of commits that no branch contains
+
+A version is kept if any rule keeps it. Tagged versions and versions with
+'keep=true' in their metadata are always kept. The files of the removed
+versions are collected as 'vio gc' does, which also collects the data left by
+versions removed earlier with 'vio rm'; the report lists it. With --dry-run,
+only shows what would be removed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		report, err := vio.Prune(pruneDryRun)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(report)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().BoolVarP(&pruneDryRun, "dry-run", "n", false, "Only show what would be removed.")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var pullCmd = &cobra.Command{
+	Use:   "pull [<remote> [<version>...]]",
+	Short: "Copy versions from a remote repository.",
+	Long: `Copies the versions of a remote that are missing, along with their tags and
+annotations. Only the given versions of the remote are copied, or all of
+them if none is given. The remote defaults to 'origin'. Nothing is copied if
+a version is in both repositories with different metadata.
+` + versionHelp,
+	Run: func(cmd *cobra.Command, args []string) {
+		name := "origin"
+		if len(args) > 0 {
+			name = args[0]
+			args = args[1:]
+		}
+		report, err := vio.Pull(name, args)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(report)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(pullCmd)
+}
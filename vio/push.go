@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var pushCmd = &cobra.Command{
+	Use:   "push [<remote> [<version>...]]",
+	Short: "Copy versions to a remote repository.",
+	Long: `Copies the versions that a remote lacks, along with their tags and
+annotations. Only the given versions are copied, or all of them if none is
+given. The remote defaults to 'origin'. Nothing is copied if a version is in
+both repositories with different metadata.
+` + versionHelp,
+	Run: func(cmd *cobra.Command, args []string) {
+		name := "origin"
+		if len(args) > 0 {
+			name = args[0]
+			args = args[1:]
+		}
+		report, err := vio.Push(name, args)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(report)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(pushCmd)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var reindexDryRun bool
+
+var reindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Rebuild the index from the stored snapshots.",
+	Long: `Rebuilds the index out of the snapshots in storage, for instance after it
+got lost or corrupted. The metadata of each version is read from the sidecar
+file stored along with its snapshot; versions committed before sidecars existed
+keep the metadata they have in the current index, if any. The previous index is
+kept in 'index.bak'. With --dry-run, only shows the versions that would be
+indexed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		report, err := vio.Reindex(reindexDryRun)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(report)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(reindexCmd)
+	reindexCmd.Flags().BoolVarP(&reindexDryRun, "dry-run", "n", false, "Only show what would be indexed.")
+}
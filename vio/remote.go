@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var remoteBackend string
+var remoteOptions []string
+var remoteInit bool
+
+var remoteCmd = &cobra.Command{
+	Use:   "remote",
+	Short: "Manage remote repositories.",
+	Long: `Lists the remote repositories that versions can be pushed to and pulled
+from. Use 'vio remote add' and 'vio remote remove' to manage them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		remotes, err := vio.ListRemotes()
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(remotes)
+	},
+}
+
+var remoteAddCmd = &cobra.Command{
+	Use:   "add <name> [<path>]",
+	Short: "Add a remote repository.",
+	Long: `Adds a remote repository. A remote is usually the snapshots folder of
+another vio repository (e.g. in a shared filesystem), whose backend is
+detected automatically. Other backends can be given with --backend and
+--option, e.g.
+
+  vio remote add origin --backend s3 -o s3_bucket=experiments -o s3_prefix=myproject
+
+With --init, the remote is initialized (using the posix backend unless
+--backend is given).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 || len(args) > 2 {
+			log.Fatalln("Expecting a name and, optionally, a path")
+		}
+		location := ""
+		if len(args) == 2 {
+			location = args[1]
+		}
+		opts := map[string]string{}
+		for _, o := range remoteOptions {
+			kv := strings.SplitN(o, "=", 2)
+			if len(kv) != 2 {
+				log.Fatalln("Expecting option of the form key=value, got " + o)
+			}
+			opts[kv[0]] = kv[1]
+		}
+		if err := vio.AddRemote(args[0], location, remoteBackend, opts, remoteInit); err != nil {
+			log.Fatalln(err.Error())
+		}
+	},
+}
+
+var remoteRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a remote repository.",
+	Long:  `Removes a remote from the configuration. Its contents are left untouched.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalln("Expecting the name of a remote")
+		}
+		if err := vio.RemoveRemote(args[0]); err != nil {
+			log.Fatalln(err.Error())
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(remoteCmd)
+	remoteCmd.AddCommand(remoteAddCmd)
+	remoteCmd.AddCommand(remoteRemoveCmd)
+	remoteAddCmd.Flags().StringVarP(&remoteBackend,
+		"backend", "b", "", "Backend of the remote")
+	remoteAddCmd.Flags().StringArrayVarP(&remoteOptions,
+		"option", "o", []string{}, "Backend option of the form key=value")
+	remoteAddCmd.Flags().BoolVar(&remoteInit, "init", false, "Initialize the remote")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var reproduceOutputs []string
+
+var reproduceCmd = &cobra.Command{
+	Use:   "reproduce <version>",
+	Short: "Re-execute the command that produced a version and compare results.",
+	Long: `Re-executes the command that produced a version, as recorded by 'vio run' or
+given in the 'cmd' key of its metadata, in a temporary git worktree at the
+revision of the version. The files of the snapshot are restored in the worktree
+before executing, except for the outputs given with --output and the output
+captured by 'vio run'. The results are committed as a new version, with the id
+of the original in its 'reproduces' key, and compared with the original outputs.
+Fails if an output differs or is missing. At least one output of the snapshot
+has to be given, otherwise there would be nothing to compare.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalln("Expecting one version")
+		}
+		report, err := vio.Reproduce(args[0], reproduceOutputs)
+		fmt.Print(report)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(reproduceCmd)
+	reproduceCmd.Flags().StringSliceVarP(&reproduceOutputs, "output", "o", []string{},
+		"File or folder produced by the command; can be given multiple times.")
+}
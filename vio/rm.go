@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var rmCmd = &cobra.Command{
+	Use:   "rm <version>...",
+	Short: "Remove versions from the index.",
+	Long: `Removes versions from the index, along with the tags that refer to them.
+Their files stay in the snapshots folder until 'vio gc' is executed.
+` + versionHelp,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Fatalln("Expecting at least one version")
+		}
+		if err := vio.Remove(args); err != nil {
+			log.Fatalln(err.Error())
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(rmCmd)
+}
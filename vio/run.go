@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var runMeta string
+var runMsg string
+
+var runCmd = &cobra.Command{
+	Use:   "run [flags] -- <command> [args...]",
+	Short: "Execute a command and commit its results.",
+	Long: `Executes a command, capturing its standard output and error in the
+` + vio.RunStdout + ` and ` + vio.RunStderr + ` files, and commits the unversioned files
+once it finishes. The command line, exit code, timings and peak memory usage
+are recorded in the metadata of the version. Failed executions are committed
+too, flagged with 'run.failed', and so are executions that modify versioned
+files, flagged with 'run.dirty' and listing them in 'run.dirty_paths'.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Fatalln("Expecting a command to execute")
+		}
+		exitCode, err := vio.Run(runMsg, runMeta, args)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(runCmd)
+	runCmd.Flags().StringVarP(&runMeta,
+		"meta", "", "{}", "JSON object with the metadata of the version.")
+	runCmd.Flags().StringVarP(&runMsg,
+		"message", "m", " ", "Commit message.")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var showStat bool
+
+var showCmd = &cobra.Command{
+	Use:   "show <version>",
+	Short: "Show a version and its files.",
+	Long: `Shows the metadata of a version, the git commit it belongs to and the files
+it contains, with their sizes, modes and SHA-256 checksums.
+` + versionHelp,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalln("Expecting a version")
+		}
+		showstr, err := vio.Show(args[0], showStat)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(showstr)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(showCmd)
+	showCmd.Flags().BoolVarP(&showStat,
+		"stat", "", false, "Only show the number of files and their total size.")
+}
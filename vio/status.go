@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show staged and unstaged files.",
+	Long: `Lists staged and unstaged unversioned files, showing whether they are new or
+were modified since the latest snapshot of the current revision.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		statusstr, err := vio.ShowStatus()
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(statusstr)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var tagDelete bool
+var tagList bool
+var tagForce bool
+
+var tagCmd = &cobra.Command{
+	Use:   "tag <name> <version>",
+	Short: "Create, list or delete tags.",
+	Long: `Gives a name to a version, so that it can be referred to by that name in
+other commands (e.g. 'vio checkout paper-fig3'). Tags are shown by 'vio log'.
+Without arguments, or with -l, lists the existing tags.
+` + versionHelp,
+	Run: func(cmd *cobra.Command, args []string) {
+		switch {
+		case tagList || (len(args) == 0 && !tagDelete):
+			tagstr, err := vio.ListTags()
+			if err != nil {
+				log.Fatalln(err.Error())
+			}
+			fmt.Print(tagstr)
+		case tagDelete:
+			if len(args) != 1 {
+				log.Fatalln("Expecting the name of the tag to delete")
+			}
+			if err := vio.DeleteTag(args[0]); err != nil {
+				log.Fatalln(err.Error())
+			}
+		default:
+			if len(args) != 2 {
+				log.Fatalln("Expecting a tag name and a version")
+			}
+			if err := vio.Tag(args[0], args[1], tagForce); err != nil {
+				log.Fatalln(err.Error())
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(tagCmd)
+	tagCmd.Flags().BoolVarP(&tagDelete, "delete", "d", false, "Delete a tag.")
+	tagCmd.Flags().BoolVarP(&tagList, "list", "l", false, "List tags.")
+	tagCmd.Flags().BoolVarP(&tagForce, "force", "f", false, "Replace an existing tag.")
+}
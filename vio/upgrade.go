@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ivotron/vio"
+	"github.com/spf13/cobra"
+)
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Migrate the repository to the current format.",
+	Long: `Migrates the index of a repository created by an older version of vio to the
+format used by this one, in place. The previous index is kept in 'index.bak'.
+Older indexes can still be read and updated without upgrading, but only
+upgraded ones can hold what newer formats add. Indexes with malformed lines
+have to be fixed with 'vio fsck --repair' or 'vio reindex' first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		report, err := vio.Upgrade()
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fmt.Print(report)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(upgradeCmd)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 
+	"github.com/ivotron/vio"
 	"github.com/spf13/cobra"
 )
 
@@ -11,7 +12,7 @@ var versionCmd = &cobra.Command{
 	Short: "Show version info.",
 	Long:  ``,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Printf("vio version 0.1.0\n")
+		fmt.Printf("vio version %s\n", vio.Version)
 	},
 }
 
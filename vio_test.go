@@ -62,6 +62,18 @@ func TestCmdInitPosixAbsolute(t *testing.T) {
 	createAndSeedTestRepo(t, path, []string{})
 	testCmdInitPosix(t, path+"/.snapshots")
 }
+func TestCmdInitWithOptions(t *testing.T) {
+	path, err := ioutil.TempDir("", "testing")
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(path))
+	createAndSeedTestRepo(t, path, []string{})
+	err = InitWithOptions(".snapshots", "posix", map[string]string{"incremental": "true"})
+	assert.Nil(t, err)
+	cfg, err := ini.Load(".vioconfig")
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.Section("").Key("incremental").String(), "true")
+	assert.False(t, cfg.Section("").HasKey("repo_path"))
+}
 func TestCmdCommitPosix(t *testing.T) {
 	path, err := ioutil.TempDir("", "testing")
 	assert.Nil(t, os.Chdir(path))
@@ -125,9 +137,9 @@ func TestContainsVersion(t *testing.T) {
 func TestVersionMeta(t *testing.T) {
 	v := NewVersion("1234567890")
 	assert.NotNil(t, v)
-	assert.Equal(t, v.meta, map[string]string{})
+	assert.Equal(t, v.meta, metadata{})
 
-	meta := map[string]string{"foo": "bar", "hello": "goodbye"}
+	meta := metadata{"foo": "bar", "hello": "goodbye"}
 	v = NewVersionWithMeta("1234567890", meta)
 	assert.NotNil(t, v)
 	assert.Equal(t, v.meta, meta)